@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dcrodman/autorsync"
+	"github.com/dcrodman/autorsync/internal/logging"
+)
+
+// How long the benchmark waits for every file to reach the target before giving up.
+const benchmarkTimeout = 2 * time.Minute
+
+// Sync files files of size bytes each between two temporary directories and print how long
+// it took for the first change to be noticed and for all of them to be synced.
+func runBenchmark(rsyncPath string, files, size int) error {
+	if files <= 0 || size < 0 {
+		return fmt.Errorf("-benchmark-files must be positive and -benchmark-size must not be negative")
+	}
+
+	dir, err := os.MkdirTemp("", "autorsync-benchmark")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	target := filepath.Join(dir, "target")
+	for _, path := range []string{source, target} {
+		if err := os.Mkdir(path, 0755); err != nil {
+			return err
+		}
+	}
+
+	configFile := filepath.Join(dir, "autorsync.json")
+	configJSON, _ := json.Marshal(map[string]interface{}{
+		"settings": map[string]interface{}{"interval": "100ms"},
+		"mappings": []map[string]interface{}{{"name": "benchmark", "source": source, "target": target}},
+	})
+	if err := os.WriteFile(configFile, configJSON, 0644); err != nil {
+		return err
+	}
+	config, err := autorsync.ReadConfig(configFile)
+	if err != nil {
+		return err
+	}
+
+	// The syncer's usual logging would drown out the results.
+	logging.SetQuiet(true)
+	defer logging.SetQuiet(*quiet)
+
+	syncer := autorsync.NewSyncer(config, autorsync.Options{RsyncPath: rsyncPath})
+	if err := syncer.Start(context.Background()); err != nil {
+		return err
+	}
+	defer syncer.Stop()
+
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	start := time.Now()
+	// Watch for the first change while the files are still being written.
+	firstEvent := make(chan time.Duration, 1)
+	go func() {
+		for syncer.Status().Mappings[0].EventCount == 0 && time.Since(start) < benchmarkTimeout {
+			time.Sleep(100 * time.Microsecond)
+		}
+		firstEvent <- time.Since(start)
+	}()
+	for i := 0; i < files; i++ {
+		if err := os.WriteFile(filepath.Join(source, fmt.Sprintf("file%d", i)), data, 0644); err != nil {
+			return err
+		}
+	}
+	written := time.Since(start)
+
+	for {
+		if syncer.Status().Mappings[0].FailedSyncCount > 0 {
+			return fmt.Errorf("rsync failed")
+		}
+		if countSyncedFiles(target, int64(size)) >= files {
+			break
+		}
+		if time.Since(start) > benchmarkTimeout {
+			return fmt.Errorf("gave up after %s waiting for %d files to be synced", benchmarkTimeout, files)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	synced := time.Since(start)
+
+	// The files can land before the rsync that copied them has been recorded.
+	for syncer.Status().SyncInProgress {
+		time.Sleep(time.Millisecond)
+	}
+	status := syncer.Status().Mappings[0]
+	total := int64(files) * int64(size)
+	fmt.Printf("%d files of %s (%s in total)\n", files, formatBytes(int64(size)), formatBytes(total))
+	fmt.Printf("  written in:        %s\n", written.Round(time.Microsecond))
+	fmt.Printf("  first change seen: %s\n", (<-firstEvent).Round(time.Microsecond))
+	fmt.Printf("  all files synced:  %s\n", synced.Round(time.Microsecond))
+	fmt.Printf("  rsync runs:        %d, taking %s\n", status.RsyncRuns, status.RsyncDuration.Round(time.Microsecond))
+	if status.RsyncDuration > 0 {
+		fmt.Printf("  rsync throughput:  %s/s\n", formatBytes(int64(float64(total)/status.RsyncDuration.Seconds())))
+	}
+	return nil
+}
+
+// Count the files of the given size under target.
+func countSyncedFiles(target string, size int64) int {
+	count := 0
+	filepath.Walk(target, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() && info.Size() == size {
+			count++
+		}
+		return nil
+	})
+	return count
+}
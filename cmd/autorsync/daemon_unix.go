@@ -0,0 +1,43 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/dcrodman/autorsync/internal/logging"
+)
+
+// Set in the environment of the background process so that it knows not to start another.
+const daemonEnv = "AUTORSYNC_DAEMONIZED"
+
+// Start a copy of autorsync in its own session with no terminal, and exit once it's running.
+// Go can't safely fork, so the copy is a fresh exec of the same binary with the same flags.
+// Returns in the background process, which carries on as normal.
+func daemonize() error {
+	if os.Getenv(daemonEnv) != "" {
+		os.Unsetenv(daemonEnv)
+		return nil
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to daemonize: %w", err)
+	}
+
+	// Leaving Stdin, Stdout and Stderr nil connects them to /dev/null.
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to daemonize: %w", err)
+	}
+
+	logging.Infof("running in the background with PID %d", cmd.Process.Pid)
+	os.Exit(0)
+	return nil
+}
@@ -0,0 +1,8 @@
+package main
+
+import "errors"
+
+// Windows has no sessions to detach into; run autorsync as a service instead.
+func daemonize() error {
+	return errors.New("-daemonize isn't supported on Windows")
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/dcrodman/autorsync/internal/logging"
+)
+
+// A starter config with one mapping. Every other setting is listed, commented out, with its
+// default or an example value; JSON configs can have // comments.
+const configTemplate = `{
+  "settings": {
+    // Arguments always passed to rsync before any others.
+    // "base_args": ["-avzh"],
+    // Extra arguments to pass to rsync, and how each mapping's rsync_args combines with them
+    // ("append" or "replace").
+    // "rsync_args": [],
+    // "per_mapping_args_mode": "append",
+    // Wait this many milliseconds for a burst of changes to settle before syncing.
+    // "debounce_ms": 0,
+    // How many mappings can be synced at the same time.
+    // "max_concurrent_syncs": 1,
+    // Most rsync runs to start per minute across all mappings (0 for no limit).
+    // "max_syncs_per_minute": 0,
+    // Cap each transfer at this many KB/s (0 for no limit).
+    // "bwlimit_kbps": 0,
+    // Ignore what .gitignore files in the source ignore, and add the patterns in a source's
+    // .autorsyncignore file to its exclusions.
+    // "respect_gitignore": false,
+    // "use_ignore_file": false,
+    // Watch the contents of symlinked directories, and files whose names start with a dot.
+    // "follow_symlinks": false,
+    // "watch_hidden_files": true,
+    // Retry failed syncs, with the delay doubling from retry_base_delay up to retry_max_delay.
+    // "retry_max_attempts": 0,
+    // "retry_base_delay": "1s",
+    // "retry_max_delay": "5m",
+    // Stop syncing a mapping for circuit_breaker_reset_after once it fails this many times in
+    // a row (0 to never stop).
+    // "circuit_breaker_threshold": 0,
+    // "circuit_breaker_reset_after": "10m",
+    // Record each mapping's syncs in this file, with transfer statistics if capture_stats is
+    // set.
+    // "status_file": "",
+    // "capture_stats": false,
+    // Keep the list of mappings with unsynced changes in this file, to sync them after a
+    // restart.
+    // "state_file": "",
+    // Append a line of JSON to this file for every run of rsync.
+    // "event_log_file": "",
+    // Show a desktop notification after each sync.
+    // "notify": false,
+    // Check that remote targets can be reached over ssh at startup.
+    // "check_remote_on_start": true,
+    // Default SSH host key checking for mappings with ssh targets.
+    // "ssh_known_hosts_file": "",
+    // "ssh_strict_host_checking": true,
+    // Stop checking for changes every interval after nothing has changed for this long.
+    // "idle_after": "",
+    // Poll sources for changes instead of watching them, and how often to poll. Sources on
+    // network filesystems are always polled.
+    // "use_polling": false,
+    // "poll_interval": "5s",
+    // Wait for sources that don't exist yet to be created instead of skipping them.
+    // "watch_for_creation": false,
+    // Give up if watching every source at startup takes longer than this ("0" for no limit).
+    // "watch_init_timeout": "30s",
+    // How long to wait for another autorsync process syncing the same mapping.
+    // "lock_timeout": "5s",
+    // Least severe messages to log: "debug", "info", "warn" or "error".
+    // "log_level": "info",
+
+    // How long to wait after a change before syncing it.
+    "interval": "1s"
+  },
+  "mappings": [
+    {
+      // A short label for log messages and the status file.
+      // "name": "myproject",
+      // Only watch and sync files with these extensions, or never those with these.
+      // "include_extensions": [],
+      // "exclude_extensions": [],
+      // Paths to ignore that are matched by regular expressions, and rsync exclude files.
+      // "exclusion_regexes": [],
+      // "exclude_from_files": [],
+      // Only watch this many levels below the source (0 for no limit).
+      // "max_depth": 0,
+      // Overrides settings.interval for this mapping.
+      // "interval": "1s",
+      // Extra arguments to pass to rsync for this mapping.
+      // "rsync_args": [],
+      // Remove files from the target that were removed from the source.
+      // "delete_extraneous": false,
+      // Compare files by checksum, keep partially transferred files, and check every file's
+      // hash after syncing.
+      // "checksum": false,
+      // "partial": false,
+      // "partial_dir": "",
+      // "verify_checksums": false,
+      // Skip syncing while more than this many files or bytes would be transferred.
+      // "max_sync_files": 0,
+      // "max_sync_bytes": 0,
+      // Lower priorities are synced first.
+      // "priority": 0,
+      // Overrides settings.bwlimit_kbps, settings.respect_gitignore and
+      // settings.follow_symlinks for this mapping.
+      // "bwlimit_kbps": 0,
+      // "respect_gitignore": false,
+      // "follow_symlinks": false,
+      // Shell commands to run before and after each sync.
+      // "pre_sync": "",
+      // "post_sync": "",
+      // Send a request to this URL after each successful sync.
+      // "webhook_url": "",
+      // "webhook_method": "POST",
+      // "webhook_timeout": "10s",
+      // "webhook_retries": 0,
+      // How to reach an ssh target.
+      // "ssh_key": "",
+      // "ssh_user": "",
+      // "ssh_port": 22,
+      // "ssh_known_hosts_file": "",
+      // "ssh_strict_host_checking": true,
+      // Sync to a module on an rsync daemon instead of over ssh.
+      // "rsync_module": "",
+      // "rsync_password_file": "",
+      // rsync executable to use for this mapping instead of -rsync.
+      // "rsync_binary": "",
+      // Set to false to keep the mapping without syncing it.
+      // "enabled": true,
+
+      // Directory, or single file, to watch and sync: the same as the SRC argument to rsync.
+      "source": "~/src/myproject",
+      // Where to sync it to: the same as the DEST argument to rsync.
+      "target": "user@example.com:/srv/myproject",
+      // Paths in the source that shouldn't be synced. Entries with *, ? or [ are glob
+      // patterns.
+      "exclusions": [".git", "*.log"]
+    }
+  ]
+}
+`
+
+// Write the starter config to path, or to stdout if path is empty. An existing file is never
+// overwritten.
+func generateConfig(path string) error {
+	if path == "" {
+		_, err := io.WriteString(os.Stdout, configTemplate)
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, configTemplate); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	logging.Infof("wrote %s", path)
+	return nil
+}
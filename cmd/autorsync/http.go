@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/dcrodman/autorsync"
+	"github.com/dcrodman/autorsync/internal/logging"
+)
+
+// Serve the current sync state on addr until the process exits.
+func startHTTPServer(addr string, syncer *autorsync.Syncer) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(syncer.Status())
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to start HTTP server: %w", err)
+	}
+	logging.Infof("serving status on %s", addr)
+	serveInBackground(listener, mux, "HTTP server")
+	return nil
+}
+
+// Serve handler on listener from a new goroutine, logging why if it ever stops.
+func serveInBackground(listener net.Listener, handler http.Handler, name string) {
+	go func() {
+		if err := http.Serve(listener, handler); err != nil {
+			logging.Errorf("%s stopped: %v", name, err)
+		}
+	}()
+}
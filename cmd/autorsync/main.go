@@ -0,0 +1,326 @@
+// autorsync is a simple utility for monitoring changes to a directory and then
+// using the system's rsync to update the files on the target.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/dcrodman/autorsync"
+	"github.com/dcrodman/autorsync/internal/logging"
+)
+
+var (
+	configFile   = flag.String("config", envOrDefault("AUTORSYNC_CONFIG", ".autorsync"), "Config file, or a comma-separated list of files to merge (defaults to $AUTORSYNC_CONFIG if set)")
+	envMappings  = flag.Bool("mappings-from-env", false, "Add a mapping for each N in AUTORSYNC_MAPPING_<N>_<KEY> environment variables, after the config file's")
+	rsync        = flag.String("rsync", "/usr/bin/rsync", "rsync executable to use")
+	logFormat    = flag.String("log-format", "text", "Log output format: text or json")
+	quiet        = flag.Bool("quiet", false, "Only log errors, whatever settings.log_level says")
+	color        = flag.String("color", "auto", "Color errors, warnings, change events and rsync output: auto (when logging to a terminal), always or never")
+	rsyncTimeout = flag.Duration("rsync-timeout", 0, "Kill rsync if it runs longer than this (e.g. 2m); 0 means no limit")
+	once         = flag.Bool("once", false, "Sync every mapping once and exit without watching for changes")
+	dryRun       = flag.Bool("dry-run", false, "Log rsync commands (with --dry-run added) instead of running them")
+	watchOnly    = flag.Bool("watch-only", false, "Never run rsync; print a line of JSON to stdout for each mapping with changes instead")
+	checkOnly    = flag.Bool("config-check", false, "Validate the config file, print a summary and exit")
+	listOnly     = flag.Bool("list-mappings", false, "Print the configured mappings and exit")
+	printOnly    = flag.Bool("config-print", false, "Print the config as JSON, with defaults and environment variables applied, and exit")
+	status       = flag.Bool("status", false, "Print the contents of settings.status_file and exit")
+	pidFile      = flag.String("pid-file", "", "Write the process ID to this file while running")
+	daemon       = flag.Bool("daemonize", false, "Detach from the terminal and keep running in the background")
+	tui          = flag.Bool("tui", false, "Show a live table of the mappings instead of scrolling logs")
+	testRsync    = flag.Bool("test-rsync", false, "Check that rsync runs and is version 3.0 or later before starting")
+	showVersion  = flag.Bool("version", false, "Print version information and exit")
+	generate     = flag.Bool("generate-config", false, "Write a starter config file to the path given by -config, or to stdout without -config, and exit")
+	httpAddr     = flag.String("http-addr", "", "Address to serve /status and /healthz on (disabled if empty)")
+	metricsAddr  = flag.String("metrics-addr", "", "Address to serve Prometheus metrics on at /metrics (disabled if empty)")
+	profileAddr  = flag.String("profile", "", "Address to serve net/http/pprof profiles on at /debug/pprof/ (disabled if empty)")
+
+	statsInterval = flag.Duration("stats-interval", 0, "Log a summary of each mapping's syncs this often (e.g. 30m); 0 disables it")
+	statsReset    = flag.Bool("stats-reset", false, "Only count the syncs since the last summary in each -stats-interval summary, instead of the totals since startup")
+
+	benchmark      = flag.Bool("benchmark", false, "Time syncing a set of new files between two temporary directories, print the results and exit")
+	benchmarkFiles = flag.Int("benchmark-files", 100, "Number of files to write with -benchmark")
+	benchmarkSize  = flag.Int("benchmark-size", 4096, "Size in bytes of each file written with -benchmark")
+
+	initialSync   = flag.Bool("initial-sync", true, "Sync every mapping on startup instead of waiting for changes")
+	noInitialSync = flag.Bool("no-initial-sync", false, "Don't sync on startup; the same as -initial-sync=false")
+
+	logFile       = flag.String("log-file", "", "Write log output to this file instead of stderr")
+	logMaxSizeMB  = flag.Int("log-max-size-mb", 0, "Rotate the log file once it reaches this size in megabytes; 0 disables rotation")
+	logMaxBackups = flag.Int("log-max-backups", 0, "Number of rotated log files to keep; 0 keeps them all")
+)
+
+func main() {
+	flag.Parse()
+	if err := logging.SetFormat(*logFormat); err != nil {
+		logging.Fatalf("invalid -log-format: %s", *logFormat)
+	}
+	logging.SetQuiet(*quiet)
+	if *logFile != "" {
+		if err := logging.SetOutputFile(*logFile, int64(*logMaxSizeMB)*1024*1024, *logMaxBackups); err != nil {
+			logging.Fatalf("%v", err)
+		}
+	}
+	if err := logging.SetColor(*color); err != nil {
+		logging.Fatalf("invalid -color: %s", *color)
+	}
+
+	if *showVersion {
+		printVersion()
+		return
+	}
+
+	if *generate {
+		path := ""
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "config" {
+				path = *configFile
+			}
+		})
+		if err := generateConfig(path); err != nil {
+			logging.Fatalf("%v", err)
+		}
+		return
+	}
+
+	if *benchmark {
+		if err := runBenchmark(*rsync, *benchmarkFiles, *benchmarkSize); err != nil {
+			logging.Fatalf("benchmark failed: %v", err)
+		}
+		return
+	}
+
+	configFiles := strings.Split(*configFile, ",")
+	for i := range configFiles {
+		configFiles[i] = strings.TrimSpace(configFiles[i])
+	}
+
+	readConfig := autorsync.ReadConfig
+	if *envMappings {
+		readConfig = autorsync.ReadConfigWithEnvMappings
+	}
+	config, err := readConfig(configFiles...)
+	if err != nil {
+		logging.Fatalf("%v", err)
+	}
+	// Already checked by ReadConfig, and ignored with -quiet.
+	logging.SetLevel(config.Settings.LogLevel)
+
+	if *checkOnly {
+		autorsync.PrintConfigSummary(os.Stdout, config)
+		if errs := autorsync.ValidateConfig(config, *rsync); len(errs) > 0 {
+			for _, err := range errs {
+				logging.Errorf("%v", err)
+			}
+			os.Exit(1)
+		}
+		fmt.Println("config OK")
+		return
+	}
+
+	if *printOnly {
+		if err := autorsync.PrintConfigJSON(os.Stdout, config); err != nil {
+			logging.Fatalf("%v", err)
+		}
+		return
+	}
+
+	if *listOnly {
+		autorsync.PrintMappings(os.Stdout, config)
+		return
+	}
+
+	if *status {
+		if config.Settings.StatusFile == "" {
+			logging.Fatalf("settings.status_file is not set")
+		}
+		if err := printStatusFile(config.Settings.StatusFile); err != nil {
+			logging.Fatalf("%v", err)
+		}
+		return
+	}
+
+	if *testRsync {
+		checkRsyncVersions(config)
+	}
+
+	var dash *dashboard
+	var onSync func()
+	if *tui {
+		dash = newDashboard()
+		onSync = dash.refresh
+	}
+
+	syncer := autorsync.NewSyncer(config, autorsync.Options{
+		RsyncPath:    *rsync,
+		ConfigFiles:  configFiles,
+		DryRun:       *dryRun,
+		RsyncTimeout: *rsyncTimeout,
+		WatchOnly:    *watchOnly,
+		InitialSync:  *initialSync && !*noInitialSync,
+
+		MappingsFromEnv: *envMappings,
+		OnSync:          onSync,
+	})
+	if dash != nil {
+		dash.syncer = syncer
+	}
+
+	// Cancelled to kill any running rsync: by the first signal with -once, or the second one
+	// otherwise.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	if *once {
+		if *watchOnly {
+			logging.Fatalf("-once and -watch-only can't be used together")
+		}
+		if *daemon {
+			logging.Fatalf("-once and -daemonize can't be used together")
+		}
+		if *tui {
+			logging.Fatalf("-once and -tui can't be used together")
+		}
+		go func() {
+			sig := <-signals
+			logging.Infof("received %s, stopping", sig)
+			cancel()
+		}()
+		if err := syncer.SyncAll(ctx); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *tui && *watchOnly {
+		logging.Fatalf("-tui and -watch-only can't be used together")
+	}
+	if *tui && *daemon {
+		logging.Fatalf("-tui and -daemonize can't be used together")
+	}
+
+	if *daemon {
+		if err := daemonize(); err != nil {
+			logging.Fatalf("%v", err)
+		}
+	}
+
+	if *pidFile != "" {
+		if err := writePIDFile(*pidFile); err != nil {
+			logging.Fatalf("%v", err)
+		}
+	}
+	err = run(ctx, cancel, syncer, dash, signals)
+	// Not deferred, since logging.Fatalf exits without running deferred calls.
+	if *pidFile != "" {
+		os.Remove(*pidFile)
+	}
+	if err != nil {
+		logging.Fatalf("%v", err)
+	}
+}
+
+// Run syncer until a signal arrives or the -tui dashboard is closed, then finish the pending
+// syncs. An error is only returned if it couldn't be started.
+func run(ctx context.Context, cancel context.CancelFunc, syncer *autorsync.Syncer, dash *dashboard, signals chan os.Signal) error {
+	if err := syncer.Start(ctx); err != nil {
+		return err
+	}
+	if err := startServers(syncer); err != nil {
+		// Cancelled first so that Stop doesn't wait for a last round of syncs before exiting.
+		cancel()
+		syncer.Stop()
+		return err
+	}
+	if *statsInterval > 0 {
+		go logStats(syncer, *statsInterval, *statsReset)
+	}
+
+	forceSignals := make(chan os.Signal, 1)
+	notifyForceSync(forceSignals)
+	go func() {
+		for sig := range forceSignals {
+			logging.Infof("received %s, syncing all mappings", sig)
+			syncer.SyncNow()
+		}
+	}()
+
+	if dash != nil {
+		if err := dash.run(signals, *logFile == ""); err != nil {
+			logging.Errorf("%v", err)
+		}
+		logging.Infof("finishing pending syncs before exiting")
+	} else {
+		sig := <-signals
+		logging.Infof("received %s, finishing pending syncs before exiting", sig)
+	}
+	go func() {
+		<-signals
+		logging.Infof("received second signal, killing rsync and exiting")
+		cancel()
+	}()
+
+	if err := syncer.Stop(); err != nil {
+		logging.Errorf("%v", err)
+	}
+	return nil
+}
+
+// Start whichever of the -http-addr, -metrics-addr and -profile servers are enabled.
+func startServers(syncer *autorsync.Syncer) error {
+	if *httpAddr != "" {
+		if err := startHTTPServer(*httpAddr, syncer); err != nil {
+			return err
+		}
+	}
+	if *metricsAddr != "" {
+		if err := startMetricsServer(*metricsAddr, syncer); err != nil {
+			return err
+		}
+	}
+	if *profileAddr != "" {
+		if err := startProfileServer(*profileAddr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check the rsync binary and every mapping's rsync_binary, exiting if any of them won't work.
+func checkRsyncVersions(config *autorsync.Config) {
+	paths := []string{*rsync}
+	for _, mapping := range config.Mappings {
+		if mapping.RsyncBinary != "" {
+			paths = append(paths, mapping.RsyncBinary)
+		}
+	}
+
+	checked := make(map[string]bool)
+	for _, path := range paths {
+		if checked[path] {
+			continue
+		}
+		checked[path] = true
+
+		version, err := autorsync.CheckRsyncVersion(path)
+		if err != nil {
+			logging.Fatalf("%v (use -rsync or rsync_binary to choose a different rsync)", err)
+		}
+		logging.Infof("%s: %s", path, version)
+	}
+}
+
+func envOrDefault(key, value string) string {
+	if env, ok := os.LookupEnv(key); ok && env != "" {
+		return env
+	}
+	return value
+}
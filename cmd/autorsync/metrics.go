@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/dcrodman/autorsync"
+	"github.com/dcrodman/autorsync/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	syncsDesc = prometheus.NewDesc("autorsync_syncs_total",
+		"Number of syncs run for a mapping, by whether they succeeded.", []string{"mapping", "status"}, nil)
+	syncDurationDesc = prometheus.NewDesc("autorsync_sync_duration_seconds",
+		"Time spent running rsync for a mapping.", []string{"mapping"}, nil)
+	dirtyMappingsDesc = prometheus.NewDesc("autorsync_dirty_mappings",
+		"Number of mappings with changes waiting to be synced.", nil, nil)
+	watcherEventsDesc = prometheus.NewDesc("autorsync_watcher_events_total",
+		"Number of file change events seen for a mapping.", []string{"mapping"}, nil)
+)
+
+// Reports metrics from the syncer's status each time it's scraped, so they always match
+// what /status shows.
+type syncerCollector struct {
+	syncer *autorsync.Syncer
+}
+
+func (c syncerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- syncsDesc
+	ch <- syncDurationDesc
+	ch <- dirtyMappingsDesc
+	ch <- watcherEventsDesc
+}
+
+func (c syncerCollector) Collect(ch chan<- prometheus.Metric) {
+	status := c.syncer.Status()
+
+	// Each series needs a label of its own, and an unnamed mapping's label is its source and
+	// target, so mappings listed more than once are added together.
+	var labels []string
+	byLabel := make(map[string]*autorsync.MappingStatus)
+	dirty := 0
+	for _, mapping := range status.Mappings {
+		if mapping.Dirty {
+			dirty++
+		}
+
+		total, ok := byLabel[mapping.Name]
+		if !ok {
+			total = &autorsync.MappingStatus{}
+			byLabel[mapping.Name] = total
+			labels = append(labels, mapping.Name)
+		}
+		total.SyncCount += mapping.SyncCount
+		total.FailedSyncCount += mapping.FailedSyncCount
+		total.RsyncRuns += mapping.RsyncRuns
+		total.RsyncDuration += mapping.RsyncDuration
+		total.EventCount += mapping.EventCount
+	}
+
+	for _, label := range labels {
+		mapping := byLabel[label]
+		succeeded := mapping.SyncCount - mapping.FailedSyncCount
+		ch <- prometheus.MustNewConstMetric(syncsDesc, prometheus.CounterValue, float64(succeeded), label, "success")
+		ch <- prometheus.MustNewConstMetric(syncsDesc, prometheus.CounterValue, float64(mapping.FailedSyncCount), label, "failure")
+		ch <- prometheus.MustNewConstSummary(syncDurationDesc, uint64(mapping.RsyncRuns),
+			mapping.RsyncDuration.Seconds(), nil, label)
+		ch <- prometheus.MustNewConstMetric(watcherEventsDesc, prometheus.CounterValue, float64(mapping.EventCount), label)
+	}
+	ch <- prometheus.MustNewConstMetric(dirtyMappingsDesc, prometheus.GaugeValue, float64(dirty))
+}
+
+// Serve Prometheus metrics for syncer on addr until the process exits.
+func startMetricsServer(addr string, syncer *autorsync.Syncer) error {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(syncerCollector{syncer}, prometheus.NewGoCollector())
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to start metrics server: %w", err)
+	}
+	logging.Infof("serving metrics on %s", addr)
+	serveInBackground(listener, mux, "metrics server")
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Write the current process's PID to path, refusing to if the file belongs to another
+// instance that's still running. A PID file left behind by a process that has exited is
+// overwritten.
+func writePIDFile(path string) error {
+	if data, err := os.ReadFile(path); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && processAlive(pid) {
+			return fmt.Errorf("autorsync is already running with PID %d (from %s)", pid, path)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read PID file: %w", err)
+	}
+
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write PID file: %w", err)
+	}
+	return nil
+}
+
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	// Signal 0 only checks whether the process exists. EPERM means it does, but belongs to
+	// someone else.
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
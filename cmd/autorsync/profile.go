@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/dcrodman/autorsync/internal/logging"
+)
+
+// Serve the net/http/pprof endpoints on addr until the process exits.
+func startProfileServer(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to start profiling server: %w", err)
+	}
+	logging.Infof("serving pprof on %s", addr)
+	serveInBackground(listener, mux, "profiling server")
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Send SIGUSR1 to c as a request to sync every mapping immediately.
+func notifyForceSync(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1)
+}
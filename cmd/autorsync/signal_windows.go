@@ -0,0 +1,6 @@
+package main
+
+import "os"
+
+// Windows has no SIGUSR1, so forced syncs aren't available.
+func notifyForceSync(c chan<- os.Signal) {}
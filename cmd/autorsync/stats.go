@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dcrodman/autorsync"
+	"github.com/dcrodman/autorsync/internal/logging"
+)
+
+// Log a summary of each mapping's syncs every interval until the process exits. With reset,
+// each summary only covers the syncs since the one before it; otherwise it's the totals since
+// startup.
+func logStats(syncer *autorsync.Syncer, interval time.Duration, reset bool) {
+	previous := make(map[string]autorsync.MappingStatus)
+	for range time.Tick(interval) {
+		for _, mapping := range syncer.Status().Mappings {
+			current := mapping
+			if reset {
+				last := previous[mapping.Name]
+				mapping.SyncCount -= last.SyncCount
+				mapping.FailedSyncCount -= last.FailedSyncCount
+				mapping.RsyncRuns -= last.RsyncRuns
+				mapping.RsyncDuration -= last.RsyncDuration
+				mapping.BytesTransferred -= last.BytesTransferred
+				previous[mapping.Name] = current
+			}
+
+			var average time.Duration
+			if mapping.RsyncRuns > 0 {
+				average = mapping.RsyncDuration / time.Duration(mapping.RsyncRuns)
+			}
+			logging.Infof("stats for %s: %d syncs, %d failed, %s transferred, %s average",
+				mapping.Name, mapping.SyncCount, mapping.FailedSyncCount,
+				formatBytes(mapping.BytesTransferred), average.Round(time.Millisecond))
+		}
+	}
+}
+
+// Format n bytes with a binary unit, e.g. "1.5MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
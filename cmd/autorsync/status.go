@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dcrodman/autorsync"
+)
+
+// Print the contents of the status file in a human-readable table.
+func printStatusFile(path string) error {
+	records, err := autorsync.ReadStatusFile(path)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSOURCE\tTARGET\tLAST SYNC\tDURATION\tEXIT CODE\tSYNCS")
+	for _, record := range records {
+		lastSync := "never"
+		if !record.LastSyncedAt.IsZero() {
+			lastSync = record.LastSyncedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%d\n", record.Name, record.Source, record.Target, lastSync,
+			record.LastDuration, record.LastExitCode, record.SyncCount)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/dcrodman/autorsync"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// How often the dashboard is redrawn between syncs, to keep the last sync times current.
+const tuiRefreshInterval = time.Second
+
+var tuiColumns = []string{"NAME", "SOURCE", "TARGET", "LAST SYNC", "DURATION", "DIRTY", "ERRORS"}
+
+// A terminal dashboard showing the state of each of a Syncer's mappings.
+type dashboard struct {
+	app    *tview.Application
+	table  *tview.Table
+	help   *tview.TextView
+	syncer *autorsync.Syncer
+	// Signalled by refresh so that syncs never wait on the application.
+	refreshes chan struct{}
+}
+
+// syncer must be set before it's started.
+func newDashboard() *dashboard {
+	d := &dashboard{
+		app:       tview.NewApplication(),
+		table:     tview.NewTable(),
+		help:      tview.NewTextView().SetText(tuiHelp),
+		refreshes: make(chan struct{}, 1),
+	}
+	d.table.SetFixed(1, 0).SetSelectable(true, false).SetBorder(true).SetTitle(" autorsync ")
+	return d
+}
+
+const tuiHelp = " r: sync the selected mapping   q: quit"
+
+// Redraw the dashboard after a sync. Safe to call from any goroutine, whether or not the
+// dashboard is running.
+func (d *dashboard) refresh() {
+	select {
+	case d.refreshes <- struct{}{}:
+	default:
+	}
+}
+
+// Show the mappings until q is pressed or a signal arrives. When showLogs is set, log output
+// goes to a pane under the table rather than the terminal while it's running.
+func (d *dashboard) run(signals <-chan os.Signal, showLogs bool) error {
+	d.update()
+
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).AddItem(d.table, 0, 2, true)
+	if showLogs {
+		logs := tview.NewTextView().SetMaxLines(1000).SetChangedFunc(func() { d.app.Draw() })
+		logs.SetBorder(true).SetTitle(" log ")
+		layout.AddItem(logs, 0, 1, false)
+
+		previous := log.Writer()
+		log.SetOutput(logs)
+		defer log.SetOutput(previous)
+	}
+	layout.AddItem(d.help, 1, 0, false)
+
+	d.app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Rune() {
+		case 'q':
+			d.app.Stop()
+			return nil
+		case 'r':
+			if row, _ := d.table.GetSelection(); row > 0 {
+				name := d.table.GetCell(row, 0).Text
+				if err := d.syncer.SyncMappingNow(name); err != nil {
+					d.help.SetText(" " + err.Error())
+				} else {
+					d.help.SetText(tuiHelp)
+				}
+			}
+			return nil
+		}
+		return event
+	})
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(tuiRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.app.QueueUpdateDraw(d.update)
+			case <-d.refreshes:
+				d.app.QueueUpdateDraw(d.update)
+			case <-signals:
+				d.app.Stop()
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return d.app.SetRoot(layout, true).Run()
+}
+
+// Fill the table from the syncer's status. Must be called from the application's goroutine
+// once it's running.
+func (d *dashboard) update() {
+	row, _ := d.table.GetSelection()
+	d.table.Clear()
+	for column, title := range tuiColumns {
+		d.table.SetCell(0, column, tview.NewTableCell(title).SetSelectable(false).SetAttributes(tcell.AttrBold))
+	}
+
+	for i, mapping := range d.syncer.Status().Mappings {
+		lastSync, duration := "never", ""
+		if mapping.LastSyncedAt != nil {
+			lastSync = fmt.Sprintf("%s ago", time.Since(*mapping.LastSyncedAt).Round(time.Second))
+			duration = mapping.LastDuration.Round(time.Millisecond).String()
+		}
+		dirty := ""
+		if mapping.Dirty {
+			dirty = "yes"
+		}
+		errors := tview.NewTableCell(fmt.Sprint(mapping.FailedSyncCount))
+		if mapping.FailedSyncCount > 0 {
+			errors.SetTextColor(tcell.ColorRed)
+		}
+
+		d.table.SetCell(i+1, 0, tview.NewTableCell(mapping.Name).SetExpansion(1))
+		d.table.SetCell(i+1, 1, tview.NewTableCell(mapping.Source).SetExpansion(1))
+		d.table.SetCell(i+1, 2, tview.NewTableCell(mapping.Target).SetExpansion(1))
+		d.table.SetCellSimple(i+1, 3, lastSync)
+		d.table.SetCellSimple(i+1, 4, duration)
+		d.table.SetCellSimple(i+1, 5, dirty)
+		d.table.SetCell(i+1, 6, errors)
+	}
+	if row > 0 && row < d.table.GetRowCount() {
+		d.table.Select(row, 0)
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import "fmt"
+
+// Set at build time with -ldflags "-X main.version=... -X main.commit=... -X main.date=...".
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)
+
+func printVersion() {
+	fmt.Printf("autorsync %s (commit %s, built %s)\n", version, commit, date)
+}
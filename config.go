@@ -0,0 +1,986 @@
+package autorsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/dcrodman/autorsync/internal/logging"
+	"gopkg.in/yaml.v2"
+)
+
+// Settings control how every mapping is synced.
+type Settings struct {
+	Interval   string   `json:"interval" yaml:"interval" toml:"interval"`
+	BaseArgs   []string `json:"base_args" yaml:"base_args" toml:"base_args"`
+	RsyncArgs  []string `json:"rsync_args" yaml:"rsync_args" toml:"rsync_args"`
+	DebounceMs int      `json:"debounce_ms" yaml:"debounce_ms" toml:"debounce_ms"`
+	// How mapping.RsyncArgs is combined with RsyncArgs: "append" (default) or "replace".
+	PerMappingArgsMode string `json:"per_mapping_args_mode" yaml:"per_mapping_args_mode" toml:"per_mapping_args_mode"`
+	StatusFile         string `json:"status_file" yaml:"status_file" toml:"status_file"`
+	MaxConcurrentSyncs int    `json:"max_concurrent_syncs" yaml:"max_concurrent_syncs" toml:"max_concurrent_syncs"`
+	RespectGitignore   bool   `json:"respect_gitignore" yaml:"respect_gitignore" toml:"respect_gitignore"`
+	FollowSymlinks     bool   `json:"follow_symlinks" yaml:"follow_symlinks" toml:"follow_symlinks"`
+	CaptureStats       bool   `json:"capture_stats" yaml:"capture_stats" toml:"capture_stats"`
+	RetryMaxAttempts   int    `json:"retry_max_attempts" yaml:"retry_max_attempts" toml:"retry_max_attempts"`
+	RetryBaseDelay     string `json:"retry_base_delay" yaml:"retry_base_delay" toml:"retry_base_delay"`
+	RetryMaxDelay      string `json:"retry_max_delay" yaml:"retry_max_delay" toml:"retry_max_delay"`
+	Notify             bool   `json:"notify" yaml:"notify" toml:"notify"`
+	// Add the patterns in each source's .autorsyncignore file to its exclusions.
+	UseIgnoreFile bool `json:"use_ignore_file" yaml:"use_ignore_file" toml:"use_ignore_file"`
+	// Limit on rsync runs across all mappings. Zero means no limit.
+	MaxSyncsPerMinute int `json:"max_syncs_per_minute" yaml:"max_syncs_per_minute" toml:"max_syncs_per_minute"`
+	// Passed to rsync as --bwlimit for mappings that don't set their own. Zero means no limit.
+	BwlimitKbps int `json:"bwlimit_kbps" yaml:"bwlimit_kbps" toml:"bwlimit_kbps"`
+	// Check that remote targets can be reached over ssh at startup. Defaults to true when not set.
+	CheckRemoteOnStart *bool `json:"check_remote_on_start" yaml:"check_remote_on_start" toml:"check_remote_on_start"`
+	// Where to keep the list of mappings with unsynced changes, so that they're synced after a restart.
+	StateFile string `json:"state_file" yaml:"state_file" toml:"state_file"`
+	// Stop ticking after this long without any changes, until the next one. Empty means never.
+	IdleAfter string `json:"idle_after" yaml:"idle_after" toml:"idle_after"`
+	// Append a line of JSON describing each run of rsync to this file.
+	EventLogFile string `json:"event_log_file" yaml:"event_log_file" toml:"event_log_file"`
+	// How often to check sources on network filesystems for changes, since they can't be watched.
+	PollInterval string `json:"poll_interval" yaml:"poll_interval" toml:"poll_interval"`
+	// Poll every source instead of watching it, for when there are too many files to watch.
+	UsePolling bool `json:"use_polling" yaml:"use_polling" toml:"use_polling"`
+	// Check for missing sources every PollInterval and start watching them once they exist.
+	WatchForCreation bool `json:"watch_for_creation" yaml:"watch_for_creation" toml:"watch_for_creation"`
+	// How long to wait for another autorsync process to finish syncing a mapping before
+	// giving up until the next tick.
+	LockTimeout string `json:"lock_timeout" yaml:"lock_timeout" toml:"lock_timeout"`
+	// How long watching every source at startup may take before giving up. Zero means no limit.
+	WatchInitTimeout string `json:"watch_init_timeout" yaml:"watch_init_timeout" toml:"watch_init_timeout"`
+	// Watch and sync files and directories whose names start with a dot. Defaults to true when
+	// not set.
+	WatchHiddenFiles *bool `json:"watch_hidden_files" yaml:"watch_hidden_files" toml:"watch_hidden_files"`
+	// Defaults for the mapping settings of the same names.
+	SSHKnownHostsFile     string `json:"ssh_known_hosts_file" yaml:"ssh_known_hosts_file" toml:"ssh_known_hosts_file"`
+	SSHStrictHostChecking *bool  `json:"ssh_strict_host_checking" yaml:"ssh_strict_host_checking" toml:"ssh_strict_host_checking"`
+	// Stop trying to sync a mapping for CircuitBreakerResetAfter once it's failed this many
+	// times in a row. Zero disables the circuit breaker.
+	CircuitBreakerThreshold  int    `json:"circuit_breaker_threshold" yaml:"circuit_breaker_threshold" toml:"circuit_breaker_threshold"`
+	CircuitBreakerResetAfter string `json:"circuit_breaker_reset_after" yaml:"circuit_breaker_reset_after" toml:"circuit_breaker_reset_after"`
+	// Least severe messages to log: "debug", "info" (default), "warn" or "error".
+	LogLevel string `json:"log_level" yaml:"log_level" toml:"log_level"`
+
+	refreshInterval time.Duration
+	retryBaseDelay  time.Duration
+	retryMaxDelay   time.Duration
+	idleAfter       time.Duration
+	pollInterval    time.Duration
+	circuitReset    time.Duration
+	watchTimeout    time.Duration
+	lockTimeout     time.Duration
+
+	// The names of the settings that were given in the config file, even as false or 0.
+	given map[string]bool
+}
+
+// Mapping is a source directory to watch and the rsync target to keep up to date with it.
+type Mapping struct {
+	// Decoded from "source", which may be a single path or a list of them. ReadConfig splits
+	// each source out into its own mapping and sets Source.
+	Sources sourceList `json:"source" yaml:"source" toml:"source"`
+	Source  string     `json:"-" yaml:"-" toml:"-"`
+	Target  string     `json:"target" yaml:"target" toml:"target"`
+	// Short label used in logs and status output instead of the source and target.
+	Name       string   `json:"name" yaml:"name" toml:"name"`
+	Exclusions []string `json:"exclusions" yaml:"exclusions" toml:"exclusions"`
+	// Regular expressions matched against paths relative to the source.
+	ExclusionRegexes []string `json:"exclusion_regexes" yaml:"exclusion_regexes" toml:"exclusion_regexes"`
+	// Only sync files with these extensions, or skip files with these extensions. Directories
+	// are never filtered.
+	IncludeExtensions []string `json:"include_extensions" yaml:"include_extensions" toml:"include_extensions"`
+	ExcludeExtensions []string `json:"exclude_extensions" yaml:"exclude_extensions" toml:"exclude_extensions"`
+	// rsync exclude files, passed as --exclude-from. Unlike Exclusions these don't affect
+	// which changes are watched, since they can use rsync's full filter syntax.
+	ExcludeFromFiles []string `json:"exclude_from_files" yaml:"exclude_from_files" toml:"exclude_from_files"`
+	// Only watch this many levels below the source. Zero means no limit. rsync still syncs
+	// the whole source.
+	MaxDepth  int      `json:"max_depth" yaml:"max_depth" toml:"max_depth"`
+	RsyncArgs []string `json:"rsync_args" yaml:"rsync_args" toml:"rsync_args"`
+	Interval  string   `json:"interval" yaml:"interval" toml:"interval"`
+	SSHKey    string   `json:"ssh_key" yaml:"ssh_key" toml:"ssh_key"`
+	SSHUser   string   `json:"ssh_user" yaml:"ssh_user" toml:"ssh_user"`
+	SSHPort   int      `json:"ssh_port" yaml:"ssh_port" toml:"ssh_port"`
+	// Check the target's host key against this file instead of ~/.ssh/known_hosts, refusing
+	// to connect to unknown hosts unless SSHStrictHostChecking is false. Setting
+	// SSHStrictHostChecking to false accepts any host key.
+	SSHKnownHostsFile     string `json:"ssh_known_hosts_file" yaml:"ssh_known_hosts_file" toml:"ssh_known_hosts_file"`
+	SSHStrictHostChecking *bool  `json:"ssh_strict_host_checking" yaml:"ssh_strict_host_checking" toml:"ssh_strict_host_checking"`
+	// Sync to this module on an rsync daemon, with Target giving the [user@]host[:path] to use.
+	RsyncModule       string `json:"rsync_module" yaml:"rsync_module" toml:"rsync_module"`
+	RsyncPasswordFile string `json:"rsync_password_file" yaml:"rsync_password_file" toml:"rsync_password_file"`
+	// rsync executable to use for this mapping instead of the Syncer's.
+	RsyncBinary string `json:"rsync_binary" yaml:"rsync_binary" toml:"rsync_binary"`
+	// Defaults to true when not set.
+	Enabled *bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// Overrides settings.RespectGitignore when set.
+	RespectGitignore *bool `json:"respect_gitignore" yaml:"respect_gitignore" toml:"respect_gitignore"`
+	// Overrides settings.FollowSymlinks when set.
+	FollowSymlinks *bool `json:"follow_symlinks" yaml:"follow_symlinks" toml:"follow_symlinks"`
+	// Passes --delete to rsync, removing files from the target that aren't in the source.
+	DeleteExtraneous bool `json:"delete_extraneous" yaml:"delete_extraneous" toml:"delete_extraneous"`
+	// Passes --checksum to rsync, comparing file contents rather than modification times and sizes.
+	Checksum bool `json:"checksum" yaml:"checksum" toml:"checksum"`
+	// Keep partially transferred files so the next sync can resume them, optionally in
+	// PartialDir. A relative PartialDir is inside each target directory.
+	Partial    bool   `json:"partial" yaml:"partial" toml:"partial"`
+	PartialDir string `json:"partial_dir" yaml:"partial_dir" toml:"partial_dir"`
+	// Compare SHA-256 hashes of the source and target files after each sync. Only local
+	// targets are supported.
+	VerifyChecksums bool `json:"verify_checksums" yaml:"verify_checksums" toml:"verify_checksums"`
+	// Skip syncing while more than this many files or bytes would be transferred. Zero means
+	// no limit.
+	MaxSyncFiles int64 `json:"max_sync_files" yaml:"max_sync_files" toml:"max_sync_files"`
+	MaxSyncBytes int64 `json:"max_sync_bytes" yaml:"max_sync_bytes" toml:"max_sync_bytes"`
+	// Shell commands run with sh -c before and after rsync. A failed pre_sync skips the sync.
+	PreSync  string `json:"pre_sync" yaml:"pre_sync" toml:"pre_sync"`
+	PostSync string `json:"post_sync" yaml:"post_sync" toml:"post_sync"`
+	// Send a request to this URL after each successful sync, retrying up to WebhookRetries
+	// times if it fails. WebhookMethod defaults to POST and WebhookTimeout to 10s.
+	WebhookURL     string `json:"webhook_url" yaml:"webhook_url" toml:"webhook_url"`
+	WebhookMethod  string `json:"webhook_method" yaml:"webhook_method" toml:"webhook_method"`
+	WebhookTimeout string `json:"webhook_timeout" yaml:"webhook_timeout" toml:"webhook_timeout"`
+	WebhookRetries int    `json:"webhook_retries" yaml:"webhook_retries" toml:"webhook_retries"`
+	// Overrides settings.BwlimitKbps when non-zero.
+	BwlimitKbps int `json:"bwlimit_kbps" yaml:"bwlimit_kbps" toml:"bwlimit_kbps"`
+	// Dirty mappings are synced lowest priority first. Ties go in config order.
+	Priority int `json:"priority" yaml:"priority" toml:"priority"`
+
+	refreshInterval time.Duration
+	gitignore       bool
+	followSymlinks  bool
+	hiddenFiles     bool
+	ignoreRules     []ignoreRule
+	// Compiled ExclusionRegexes and the rsync patterns that they could be converted to.
+	exclusionRegexes       []*regexp.Regexp
+	rsyncExclusionPatterns []string
+	nextSyncAt             time.Time
+	stats                  syncStats
+	webhookTimeout         time.Duration
+	// Target with its placeholders filled in for the current sync.
+	syncTarget string
+
+	// Consecutive failed syncs and when the next retry is allowed.
+	failures int
+	retryAt  time.Time
+	// Failures since the last successful sync, for the circuit breaker, and when the
+	// circuit breaker lets the mapping be synced again.
+	consecutiveFailures int
+	circuitOpenUntil    time.Time
+	// Whether the last attempt to sync was held back by max_syncs_per_minute.
+	rateLimited bool
+	// Whether the pending changes were over max_sync_files or max_sync_bytes. They aren't
+	// measured again until the mapping is marked as changed again or a sync is forced.
+	oversized bool
+	// The latest change seen since the mapping was last synced, for watch-only output.
+	lastEventPath string
+	// Whether the source is polled for changes rather than watched, and what it looked like
+	// on the last poll.
+	polling  bool
+	snapshot map[string]fileState
+	// Whether the source didn't exist when it was due to be watched. Missing mappings aren't
+	// synced.
+	missing bool
+	// Set along with missing when the source is deleted while it's being watched. These are
+	// watched again once the source comes back, even without watch_for_creation.
+	sourceGone bool
+}
+
+// Config is the decoded contents of a config file.
+type Config struct {
+	Settings *Settings  `json:"settings" yaml:"settings" toml:"settings"`
+	Mappings []*Mapping `json:"mappings" yaml:"mappings" toml:"mappings"`
+}
+
+// ReadConfig reads and checks the given config files, filling in defaults for any settings
+// that aren't set. When there are several files, their mappings are combined and each
+// setting is taken from the first file that sets it.
+func ReadConfig(configFiles ...string) (*Config, error) {
+	return readConfig(configFiles, false)
+}
+
+// ReadConfigWithEnvMappings is like ReadConfig, but also adds a mapping for each number N
+// used in AUTORSYNC_MAPPING_<N>_<KEY> environment variables, after the config files' own.
+// KEY is the upper-cased name of a mapping setting, e.g. AUTORSYNC_MAPPING_0_SOURCE.
+func ReadConfigWithEnvMappings(configFiles ...string) (*Config, error) {
+	return readConfig(configFiles, true)
+}
+
+func readConfig(configFiles []string, envMappings bool) (*Config, error) {
+	if len(configFiles) == 0 {
+		return nil, fmt.Errorf("no config file given")
+	}
+
+	var conf Config
+	for _, configFile := range configFiles {
+		fileConf, err := decodeConfigFile(configFile)
+		if err != nil {
+			return nil, err
+		}
+		// Relative sources are relative to the config file they're in.
+		if err := resolveSources(fileConf.Mappings, filepath.Dir(configFile)); err != nil {
+			return nil, err
+		}
+
+		if conf.Settings == nil {
+			conf.Settings = fileConf.Settings
+		} else {
+			mergeSettings(conf.Settings, fileConf.Settings)
+		}
+		conf.Mappings = append(conf.Mappings, fileConf.Mappings...)
+	}
+	if envMappings {
+		mappings, err := mappingsFromEnv(os.Environ())
+		if err != nil {
+			return nil, err
+		}
+		if err := resolveSources(mappings, "."); err != nil {
+			return nil, err
+		}
+		conf.Mappings = append(conf.Mappings, mappings...)
+	}
+
+	if err := applyEnvOverrides(conf.Settings); err != nil {
+		return nil, err
+	}
+
+	var err error
+	conf.Settings.refreshInterval, err = time.ParseDuration(conf.Settings.Interval)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse interval: %w", err)
+	} else if conf.Settings.refreshInterval <= 0 {
+		return nil, fmt.Errorf("interval must be positive: %s", conf.Settings.Interval)
+	}
+
+	// An explicitly empty base_args is respected; only a missing one gets the default.
+	if conf.Settings.BaseArgs == nil {
+		conf.Settings.BaseArgs = []string{"-avzh"}
+	}
+
+	if conf.Settings.MaxConcurrentSyncs == 0 {
+		conf.Settings.MaxConcurrentSyncs = 1
+	} else if conf.Settings.MaxConcurrentSyncs < 0 {
+		return nil, fmt.Errorf("max_concurrent_syncs must be positive: %d", conf.Settings.MaxConcurrentSyncs)
+	}
+
+	if conf.Settings.MaxSyncsPerMinute < 0 {
+		return nil, fmt.Errorf("max_syncs_per_minute must not be negative: %d", conf.Settings.MaxSyncsPerMinute)
+	}
+	if conf.Settings.BwlimitKbps < 0 {
+		return nil, fmt.Errorf("bwlimit_kbps must not be negative: %d", conf.Settings.BwlimitKbps)
+	}
+
+	if conf.Settings.RetryMaxAttempts < 0 {
+		return nil, fmt.Errorf("retry_max_attempts must not be negative: %d", conf.Settings.RetryMaxAttempts)
+	}
+	conf.Settings.retryBaseDelay, err = parseDurationSetting("retry_base_delay", conf.Settings.RetryBaseDelay, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	conf.Settings.retryMaxDelay, err = parseDurationSetting("retry_max_delay", conf.Settings.RetryMaxDelay, 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	conf.Settings.idleAfter, err = parseDurationSetting("idle_after", conf.Settings.IdleAfter, 0)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Settings.CircuitBreakerThreshold < 0 {
+		return nil, fmt.Errorf("circuit_breaker_threshold must not be negative: %d", conf.Settings.CircuitBreakerThreshold)
+	}
+	conf.Settings.circuitReset, err = parseDurationSetting("circuit_breaker_reset_after", conf.Settings.CircuitBreakerResetAfter, 10*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	conf.Settings.watchTimeout, err = parseDurationSetting("watch_init_timeout", conf.Settings.WatchInitTimeout, 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	conf.Settings.lockTimeout, err = parseDurationSetting("lock_timeout", conf.Settings.LockTimeout, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	conf.Settings.pollInterval, err = parseDurationSetting("poll_interval", conf.Settings.PollInterval, 5*time.Second)
+	if err != nil {
+		return nil, err
+	} else if conf.Settings.pollInterval == 0 {
+		return nil, fmt.Errorf("poll_interval must be positive: %s", conf.Settings.PollInterval)
+	}
+
+	switch conf.Settings.PerMappingArgsMode {
+	case "":
+		conf.Settings.PerMappingArgsMode = "append"
+	case "append", "replace":
+	default:
+		return nil, fmt.Errorf("invalid per_mapping_args_mode: %s", conf.Settings.PerMappingArgsMode)
+	}
+
+	switch conf.Settings.LogLevel {
+	case "":
+		conf.Settings.LogLevel = "info"
+	case "debug", "info", "warn", "error":
+	default:
+		return nil, fmt.Errorf("invalid log_level: %s", conf.Settings.LogLevel)
+	}
+
+	// A mapping with several sources runs one rsync per source into the same target, and
+	// sources with glob patterns stand for every directory they match.
+	var mappings []*Mapping
+	for _, m := range conf.Mappings {
+		if len(m.Sources) == 0 {
+			return nil, fmt.Errorf("mapping to %s has no source", m.Target)
+		}
+
+		var sources []string
+		for _, source := range m.Sources {
+			matches, err := expandSourceGlob(source)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, matches...)
+		}
+
+		for _, source := range sources {
+			expanded := *m
+			expanded.Sources = sourceList{source}
+			expanded.Source = source
+			expanded.Exclusions = append([]string(nil), m.Exclusions...)
+			// {basename} lets globbed sources each have their own target.
+			basename := filepath.Base(source)
+			expanded.Target = strings.ReplaceAll(m.Target, "{basename}", basename)
+			expanded.Name = strings.ReplaceAll(m.Name, "{basename}", basename)
+			// Without {basename}, a name shared by several sources is told apart by each one's.
+			if len(sources) > 1 && m.Name != "" && expanded.Name == m.Name {
+				expanded.Name = m.Name + "/" + basename
+			}
+			mappings = append(mappings, &expanded)
+		}
+	}
+	conf.Mappings = mappings
+
+	names := make(map[string]bool)
+	for _, mapping := range conf.Mappings {
+		if mapping.Name != "" {
+			if names[mapping.Name] {
+				return nil, fmt.Errorf("mapping name %s is used more than once", mapping.Name)
+			}
+			names[mapping.Name] = true
+		}
+	}
+
+	for _, mapping := range conf.Mappings {
+		if err := prepareMapping(conf.Settings, mapping, configFiles); err != nil {
+			return nil, err
+		}
+	}
+
+	seen := make(map[[2]string]bool)
+	for _, mapping := range conf.Mappings {
+		pair := [2]string{mapping.Source, mapping.Target}
+		if seen[pair] {
+			mappingLog(mapping).Warnf("%s is mapped to %s more than once", mapping.Source, mapping.Target)
+		}
+		seen[pair] = true
+	}
+
+	conf.Settings.StatusFile = os.ExpandEnv(conf.Settings.StatusFile)
+
+	return &conf, nil
+}
+
+// Resolve mapping's settings, expand its paths and check that it's valid. configFiles are
+// excluded from the sync.
+func prepareMapping(settings *Settings, mapping *Mapping, configFiles []string) error {
+	var err error
+	mapping.refreshInterval = settings.refreshInterval
+	if mapping.Interval != "" {
+		mapping.refreshInterval, err = time.ParseDuration(mapping.Interval)
+		if err != nil {
+			return fmt.Errorf("failed to parse interval for %s: %w", mapping.Source, err)
+		} else if mapping.refreshInterval <= 0 {
+			return fmt.Errorf("interval for %s must be positive: %s", mapping.Source, mapping.Interval)
+		}
+	}
+
+	mapping.gitignore = settings.RespectGitignore
+	if mapping.RespectGitignore != nil {
+		mapping.gitignore = *mapping.RespectGitignore
+	}
+
+	mapping.hiddenFiles = settings.WatchHiddenFiles == nil || *settings.WatchHiddenFiles
+
+	mapping.followSymlinks = settings.FollowSymlinks
+	if mapping.FollowSymlinks != nil {
+		mapping.followSymlinks = *mapping.FollowSymlinks
+	}
+
+	if mapping.Source, err = expandPath(mapping.Source); err != nil {
+		return err
+	}
+	if mapping.Target, err = expandPath(mapping.Target); err != nil {
+		return err
+	}
+	if strings.Contains(mapping.Target, "{mapping_name}") && mapping.Name == "" {
+		return fmt.Errorf("target for %s uses {mapping_name}, but the mapping has no name", mapping.Source)
+	}
+	mapping.Sources = sourceList{mapping.Source}
+	if settings.UseIgnoreFile && !isRegularFile(mapping.Source) {
+		exclusions, err := readExclusionsFile(filepath.Join(mapping.Source, ".autorsyncignore"))
+		if err != nil {
+			return fmt.Errorf("failed to read .autorsyncignore for %s: %w", mapping.Source, err)
+		}
+		mapping.Exclusions = append(mapping.Exclusions, exclusions...)
+	}
+
+	if mapping.RsyncBinary, err = expandPath(mapping.RsyncBinary); err != nil {
+		return err
+	}
+	for i := range mapping.ExcludeFromFiles {
+		if mapping.ExcludeFromFiles[i], err = expandPath(mapping.ExcludeFromFiles[i]); err != nil {
+			return err
+		}
+	}
+
+	// Extensions can be written with or without the leading dot.
+	for i, ext := range mapping.IncludeExtensions {
+		mapping.IncludeExtensions[i] = "." + strings.TrimPrefix(ext, ".")
+	}
+	for i, ext := range mapping.ExcludeExtensions {
+		mapping.ExcludeExtensions[i] = "." + strings.TrimPrefix(ext, ".")
+	}
+
+	for _, exclusion := range mapping.ExclusionRegexes {
+		re, err := regexp.Compile(exclusion)
+		if err != nil {
+			return fmt.Errorf("invalid exclusion regex for %s: %w", mapping.Source, err)
+		}
+		mapping.exclusionRegexes = append(mapping.exclusionRegexes, re)
+
+		// rsync doesn't understand regexes, so get as close as its patterns allow.
+		if pattern, ok := regexToRsyncPattern(mapping, exclusion); ok {
+			mappingLog(mapping).Infof("passing exclusion regex %s to rsync as --exclude=%s", exclusion, pattern)
+			mapping.rsyncExclusionPatterns = append(mapping.rsyncExclusionPatterns, pattern)
+		} else {
+			mappingLog(mapping).Warnf("exclusion regex %s can't be converted to an rsync pattern; it only "+
+				"keeps changes from triggering syncs and rsync will still transfer matching files", exclusion)
+		}
+	}
+
+	if mapping.MaxDepth < 0 {
+		return fmt.Errorf("max_depth for %s must not be negative: %d", mapping.Source, mapping.MaxDepth)
+	}
+	if mapping.BwlimitKbps < 0 {
+		return fmt.Errorf("bwlimit_kbps for %s must not be negative: %d", mapping.Source, mapping.BwlimitKbps)
+	}
+	if mapping.SSHPort < 0 || mapping.SSHPort > 65535 {
+		return fmt.Errorf("invalid ssh_port for %s: %d", mapping.Source, mapping.SSHPort)
+	}
+	mapping.SSHKey = os.ExpandEnv(mapping.SSHKey)
+	mapping.SSHUser = os.ExpandEnv(mapping.SSHUser)
+	if mapping.SSHKnownHostsFile, err = expandPath(mapping.SSHKnownHostsFile); err != nil {
+		return err
+	}
+	if sshCommand(mapping) != "" && mapping.targetKind() != targetSSH {
+		mappingLog(mapping).Warnf("ignoring SSH options for %s, which isn't reached over ssh", mapping.Target)
+	}
+	// Only after the warning, since the defaults apply to every mapping.
+	if mapping.SSHKnownHostsFile == "" {
+		if mapping.SSHKnownHostsFile, err = expandPath(settings.SSHKnownHostsFile); err != nil {
+			return err
+		}
+	}
+	if mapping.SSHStrictHostChecking == nil {
+		mapping.SSHStrictHostChecking = settings.SSHStrictHostChecking
+	}
+	if mapping.VerifyChecksums && mapping.targetKind() != targetLocal {
+		return fmt.Errorf("verify_checksums for %s needs a local target", mapping.Source)
+	}
+	if mapping.RsyncPasswordFile != "" {
+		if mapping.targetKind() != targetDaemon {
+			return fmt.Errorf("rsync_password_file for %s needs an rsync daemon target", mapping.Source)
+		}
+		if mapping.RsyncPasswordFile, err = expandPath(mapping.RsyncPasswordFile); err != nil {
+			return err
+		}
+	}
+
+	if mapping.WebhookURL != "" {
+		if err := parseWebhook(mapping); err != nil {
+			return err
+		}
+	}
+
+	// Automatically ignore the autorsync config files.
+	mapping.Exclusions = append(mapping.Exclusions, configFiles...)
+	return nil
+}
+
+func decodeConfigFile(configFile string) (*Config, error) {
+	var conf Config
+
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file: %w", err)
+	}
+
+	decoder := decoderFor(configFile)
+	if err := decoder.Decode(data, &conf); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", configFile, err)
+	}
+
+	// Decoded again to see which settings are there, since an explicit false or 0 decodes
+	// the same as one that's left out.
+	var keys struct {
+		Settings map[string]interface{} `json:"settings" yaml:"settings" toml:"settings"`
+	}
+	if err := decoder.Decode(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", configFile, err)
+	}
+
+	if conf.Settings == nil {
+		conf.Settings = &Settings{}
+	}
+	conf.Settings.given = make(map[string]bool)
+	for key := range keys.Settings {
+		conf.Settings.given[key] = true
+	}
+	return &conf, nil
+}
+
+// Fill in any settings that weren't given for dst with their values from src.
+func mergeSettings(dst, src *Settings) {
+	dv := reflect.ValueOf(dst).Elem()
+	sv := reflect.ValueOf(src).Elem()
+	for i := 0; i < dv.NumField(); i++ {
+		// Derived settings are computed after merging.
+		field := dv.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		if name := field.Tag.Get("json"); !dst.given[name] && src.given[name] {
+			dv.Field(i).Set(sv.Field(i))
+			dst.given[name] = true
+		}
+	}
+}
+
+// Parse an optional duration from the config, using defaultValue when it isn't set.
+func parseDurationSetting(name, value string, defaultValue time.Duration) (time.Duration, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %w", name, err)
+	} else if d < 0 {
+		return 0, fmt.Errorf("%s must not be negative: %s", name, value)
+	}
+	return d, nil
+}
+
+// Expand environment variables and a leading ~ in path. Remote paths like host:~/dir are
+// left for the remote shell to expand.
+func expandPath(path string) (string, error) {
+	path = os.ExpandEnv(path)
+	if !strings.HasPrefix(path, "~") {
+		return path, nil
+	}
+
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return "", fmt.Errorf("cannot expand %s: only ~ for the current user's home directory is supported", path)
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot expand %s: %w", path, err)
+	}
+	// Not filepath.Join, which would drop a trailing slash that's significant to rsync.
+	return home + path[1:], nil
+}
+
+// Make the relative sources of mappings absolute by resolving them against baseDir. A trailing
+// slash, which tells rsync to copy a directory's contents rather than the directory itself, is
+// kept.
+func resolveSources(mappings []*Mapping, baseDir string) error {
+	for _, mapping := range mappings {
+		for i, source := range mapping.Sources {
+			source, err := expandPath(source)
+			if err != nil {
+				return err
+			}
+			if filepath.IsAbs(source) {
+				mapping.Sources[i] = source
+				continue
+			}
+
+			resolved, err := filepath.Abs(filepath.Join(baseDir, source))
+			if err != nil {
+				return fmt.Errorf("cannot resolve source %s: %w", source, err)
+			}
+			if strings.HasSuffix(source, "/") {
+				resolved += "/"
+			}
+			mapping.Sources[i] = resolved
+		}
+	}
+	return nil
+}
+
+// Return the directories matching source if it's a glob pattern, or just source if it isn't.
+func expandSourceGlob(source string) ([]string, error) {
+	if !strings.ContainsAny(source, "*?[") {
+		return []string{source}, nil
+	}
+
+	pattern, err := expandPath(source)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source pattern %s: %w", source, err)
+	}
+
+	var dirs []string
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil && info.IsDir() {
+			dirs = append(dirs, match)
+		}
+	}
+	if len(dirs) == 0 {
+		logging.Warnf("source pattern %s doesn't match any directories", source)
+	}
+	return dirs, nil
+}
+
+// configDecoder parses the raw contents of a config file.
+type configDecoder interface {
+	Decode(data []byte, v interface{}) error
+}
+
+type jsonDecoder struct{}
+
+func (jsonDecoder) Decode(data []byte, v interface{}) error {
+	return json.Unmarshal(stripJSONComments(data), v)
+}
+
+// Blank out // comments, which JSON doesn't allow but config files may use, leaving strings
+// alone. Comments are replaced with spaces so that error offsets still point at the right place.
+func stripJSONComments(data []byte) []byte {
+	out := make([]byte, len(data))
+	copy(out, data)
+
+	inString, inComment := false, false
+	for i := 0; i < len(out); i++ {
+		switch {
+		case inComment:
+			if out[i] == '\n' {
+				inComment = false
+			} else {
+				out[i] = ' '
+			}
+		case inString:
+			if out[i] == '\\' {
+				i++
+			} else if out[i] == '"' {
+				inString = false
+			}
+		case out[i] == '"':
+			inString = true
+		case out[i] == '/' && i+1 < len(out) && out[i+1] == '/':
+			inComment = true
+			out[i] = ' '
+		}
+	}
+	return out
+}
+
+type tomlDecoder struct{}
+
+func (tomlDecoder) Decode(data []byte, v interface{}) error {
+	return toml.Unmarshal(data, v)
+}
+
+type yamlDecoder struct{}
+
+func (yamlDecoder) Decode(data []byte, v interface{}) error {
+	return yaml.Unmarshal(data, v)
+}
+
+// Pick a decoder based on the config file's extension. Anything unrecognized (including
+// the extension-less default of .autorsync) is treated as JSON.
+func decoderFor(configFile string) configDecoder {
+	switch strings.ToLower(filepath.Ext(configFile)) {
+	case ".yaml", ".yml":
+		return yamlDecoder{}
+	case ".toml":
+		return tomlDecoder{}
+	default:
+		return jsonDecoder{}
+	}
+}
+
+// ValidateConfig checks the parts of conf that ReadConfig can't, including that rsyncPath
+// is executable, returning every problem found.
+func ValidateConfig(conf *Config, rsyncPath string) []error {
+	var errs []error
+
+	for _, mapping := range conf.Mappings {
+		info, err := os.Stat(mapping.Source)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("source: %w", err))
+		} else if !info.IsDir() && !info.Mode().IsRegular() {
+			errs = append(errs, fmt.Errorf("source %s is not a directory or regular file", mapping.Source))
+		}
+
+		for _, excludeFrom := range mapping.ExcludeFromFiles {
+			if _, err := os.Stat(excludeFrom); err != nil {
+				errs = append(errs, fmt.Errorf("exclude_from_files: %w", err))
+			}
+		}
+	}
+
+	rsyncPaths := []string{rsyncPath}
+	for _, mapping := range conf.Mappings {
+		if mapping.RsyncBinary != "" {
+			rsyncPaths = append(rsyncPaths, mapping.RsyncBinary)
+		}
+	}
+	checked := make(map[string]bool)
+	for _, path := range rsyncPaths {
+		if checked[path] {
+			continue
+		}
+		checked[path] = true
+
+		if info, err := os.Stat(path); err != nil {
+			errs = append(errs, fmt.Errorf("rsync executable: %w", err))
+		} else if info.IsDir() || info.Mode()&0111 == 0 {
+			errs = append(errs, fmt.Errorf("rsync executable %s is not executable", path))
+		}
+	}
+
+	return errs
+}
+
+// PrintConfigSummary writes a human-readable summary of the settings and mappings in conf to w.
+func PrintConfigSummary(w io.Writer, conf *Config) {
+	fmt.Fprintln(w, "settings:")
+	fmt.Fprintln(w, "  interval:", conf.Settings.refreshInterval)
+	fmt.Fprintln(w, "  base_args:", strings.Join(conf.Settings.BaseArgs, " "))
+	fmt.Fprintln(w, "  rsync_args:", strings.Join(conf.Settings.RsyncArgs, " "))
+	fmt.Fprintln(w, "  per_mapping_args_mode:", conf.Settings.PerMappingArgsMode)
+	fmt.Fprintln(w, "  debounce_ms:", conf.Settings.DebounceMs)
+	fmt.Fprintln(w, "  max_concurrent_syncs:", conf.Settings.MaxConcurrentSyncs)
+	if conf.Settings.StatusFile != "" {
+		fmt.Fprintln(w, "  status_file:", conf.Settings.StatusFile)
+	}
+	fmt.Fprintln(w, "mappings:")
+	PrintMappings(w, conf)
+}
+
+// PrintConfigJSON writes conf to w as indented JSON, with defaults and the settings that each
+// mapping inherits filled in.
+func PrintConfigJSON(w io.Writer, conf *Config) error {
+	settings := *conf.Settings
+	settings.Interval = conf.Settings.refreshInterval.String()
+	settings.RetryBaseDelay = conf.Settings.retryBaseDelay.String()
+	settings.RetryMaxDelay = conf.Settings.retryMaxDelay.String()
+	settings.IdleAfter = conf.Settings.idleAfter.String()
+	settings.PollInterval = conf.Settings.pollInterval.String()
+	settings.CircuitBreakerResetAfter = conf.Settings.circuitReset.String()
+	hiddenFiles := conf.Settings.WatchHiddenFiles == nil || *conf.Settings.WatchHiddenFiles
+	settings.WatchHiddenFiles = &hiddenFiles
+	settings.WatchInitTimeout = conf.Settings.watchTimeout.String()
+	settings.LockTimeout = conf.Settings.lockTimeout.String()
+	checkRemote := conf.Settings.CheckRemoteOnStart == nil || *conf.Settings.CheckRemoteOnStart
+	settings.CheckRemoteOnStart = &checkRemote
+
+	effective := Config{Settings: &settings}
+	for _, m := range conf.Mappings {
+		mapping := *m
+		enabled := m.isEnabled()
+		mapping.Enabled = &enabled
+		mapping.Interval = m.refreshInterval.String()
+		mapping.RespectGitignore = &m.gitignore
+		mapping.FollowSymlinks = &m.followSymlinks
+		if mapping.BwlimitKbps == 0 {
+			mapping.BwlimitKbps = conf.Settings.BwlimitKbps
+		}
+		if mapping.WebhookURL != "" {
+			mapping.WebhookTimeout = m.webhookTimeout.String()
+		}
+		effective.Mappings = append(effective.Mappings, &mapping)
+	}
+
+	data, err := json.MarshalIndent(effective, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// PrintMappings writes a human-readable list of the mappings in conf to w.
+func PrintMappings(w io.Writer, conf *Config) {
+	for _, mapping := range conf.Mappings {
+		if mapping.Name != "" {
+			fmt.Fprintf(w, "  %s: %s -> %s\n", mapping.Name, mapping.Source, mapping.Target)
+		} else {
+			fmt.Fprintf(w, "  %s -> %s\n", mapping.Source, mapping.Target)
+		}
+		if !mapping.isEnabled() {
+			fmt.Fprintln(w, "    disabled")
+		}
+		fmt.Fprintln(w, "    interval:", mapping.refreshInterval)
+		fmt.Fprintln(w, "    exclusions:", strings.Join(mapping.Exclusions, ", "))
+		if len(mapping.RsyncArgs) > 0 {
+			fmt.Fprintln(w, "    rsync_args:", strings.Join(mapping.RsyncArgs, " "))
+		}
+		if ssh := sshCommand(mapping); ssh != "" {
+			fmt.Fprintln(w, "    ssh:", ssh)
+		}
+	}
+}
+
+// Override settings with any AUTORSYNC_<KEY> environment variables, where KEY is the
+// upper-cased name of the setting in the config file (e.g. AUTORSYNC_INTERVAL). List
+// settings are split on whitespace. AUTORSYNC_RSYNC_ARGS is added to the end of rsync_args
+// rather than replacing it, so that flags can be injected without repeating the config's.
+func applyEnvOverrides(s *Settings) error {
+	v := reflect.ValueOf(s).Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("yaml")
+		if key == "" {
+			continue
+		}
+
+		value, ok := os.LookupEnv("AUTORSYNC_" + strings.ToUpper(key))
+		if !ok {
+			continue
+		}
+
+		field := v.Field(i)
+		if key == "rsync_args" {
+			field.Set(reflect.AppendSlice(field, reflect.ValueOf(strings.Fields(value))))
+			continue
+		}
+		if err := setFromEnv(field, value); err != nil {
+			return fmt.Errorf("invalid value for AUTORSYNC_%s: %w", strings.ToUpper(key), err)
+		}
+	}
+
+	return nil
+}
+
+// Set a settings or mapping field from the value of an environment variable. Lists are split
+// on whitespace, and the *bool fields are the settings that default to true. Paths can have
+// spaces in them, so a mapping's sources are split like $PATH instead.
+func setFromEnv(field reflect.Value, value string) error {
+	if field.Type() == reflect.TypeOf(sourceList{}) {
+		field.Set(reflect.ValueOf(sourceList(filepath.SplitList(value))))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Ptr:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(&b))
+	case reflect.Slice:
+		field.Set(reflect.ValueOf(strings.Fields(value)).Convert(field.Type()))
+	default:
+		return fmt.Errorf("can't be set from the environment")
+	}
+	return nil
+}
+
+// One or more source paths, written in the config file as either a string or a list.
+type sourceList []string
+
+func (s *sourceList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*s = sourceList{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("source must be a string or a list of strings")
+	}
+	*s = list
+	return nil
+}
+
+func (s sourceList) MarshalJSON() ([]byte, error) {
+	if len(s) == 1 {
+		return json.Marshal(s[0])
+	}
+	return json.Marshal([]string(s))
+}
+
+func (s *sourceList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var single string
+	if err := unmarshal(&single); err == nil {
+		*s = sourceList{single}
+		return nil
+	}
+
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return fmt.Errorf("source must be a string or a list of strings")
+	}
+	*s = list
+	return nil
+}
+
+func (s *sourceList) UnmarshalTOML(data interface{}) error {
+	switch v := data.(type) {
+	case string:
+		*s = sourceList{v}
+	case []interface{}:
+		list := make(sourceList, 0, len(v))
+		for _, item := range v {
+			source, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("source must be a string or a list of strings")
+			}
+			list = append(list, source)
+		}
+		*s = list
+	default:
+		return fmt.Errorf("source must be a string or a list of strings")
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package autorsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Write a config file with the given contents and return its path.
+func writeConfig(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPrintConfigJSONDefaults(t *testing.T) {
+	source := t.TempDir()
+	configFile := writeConfig(t, "autorsync.json", `{
+		"settings": {"interval": "2000ms", "bwlimit_kbps": 100},
+		"mappings": [
+			{"source": "`+source+`", "target": "/backup"},
+			{"source": "`+source+`", "target": "/backup2", "webhook_url": "http://localhost/synced"}
+		]
+	}`)
+	config, err := ReadConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := PrintConfigJSON(&buf, config); err != nil {
+		t.Fatal(err)
+	}
+	var printed struct {
+		Settings map[string]interface{}   `json:"settings"`
+		Mappings []map[string]interface{} `json:"mappings"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &printed); err != nil {
+		t.Fatalf("printed invalid JSON: %v\n%s", err, buf.Bytes())
+	}
+
+	settings := map[string]interface{}{
+		"interval":                    "2s",
+		"base_args":                   []interface{}{"-avzh"},
+		"max_concurrent_syncs":        1.0,
+		"per_mapping_args_mode":       "append",
+		"log_level":                   "info",
+		"retry_base_delay":            "1s",
+		"retry_max_delay":             "5m0s",
+		"check_remote_on_start":       true,
+		"lock_timeout":                "5s",
+		"watch_init_timeout":          "30s",
+		"watch_hidden_files":          true,
+		"circuit_breaker_reset_after": "10m0s",
+		"poll_interval":               "5s",
+		"idle_after":                  "0s",
+	}
+	for key, want := range settings {
+		if got := printed.Settings[key]; !reflect.DeepEqual(got, want) {
+			t.Errorf("settings.%s = %#v, want %#v", key, got, want)
+		}
+	}
+
+	mapping := map[string]interface{}{
+		"interval":          "2s",
+		"enabled":           true,
+		"respect_gitignore": false,
+		"follow_symlinks":   false,
+		"bwlimit_kbps":      100.0,
+	}
+	for key, want := range mapping {
+		if got := printed.Mappings[0][key]; !reflect.DeepEqual(got, want) {
+			t.Errorf("mappings[0].%s = %#v, want %#v", key, got, want)
+		}
+	}
+
+	webhook := map[string]interface{}{
+		"webhook_method":  "POST",
+		"webhook_timeout": "10s",
+	}
+	for key, want := range webhook {
+		if got := printed.Mappings[1][key]; !reflect.DeepEqual(got, want) {
+			t.Errorf("mappings[1].%s = %#v, want %#v", key, got, want)
+		}
+	}
+}
+
+func TestReadConfigMergesSettings(t *testing.T) {
+	source := t.TempDir()
+	first := writeConfig(t, "autorsync.json", `{
+		"settings": {"interval": "5s", "respect_gitignore": false, "debounce_ms": 0},
+		"mappings": [{"source": "`+source+`", "target": "/backup"}]
+	}`)
+	second := writeConfig(t, "autorsync.yaml", `
+settings:
+  interval: 10s
+  respect_gitignore: true
+  debounce_ms: 250
+  capture_stats: true
+`)
+	third := writeConfig(t, "autorsync.toml", `
+[settings]
+capture_stats = false
+status_file = "status.json"
+`)
+
+	config, err := ReadConfig(first, second, third)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An explicit false or 0 in an earlier file still counts as set.
+	settings := config.Settings
+	if settings.Interval != "5s" || settings.RespectGitignore || settings.DebounceMs != 0 {
+		t.Errorf("got interval %q, respect_gitignore %v and debounce_ms %d, want the first file's",
+			settings.Interval, settings.RespectGitignore, settings.DebounceMs)
+	}
+	if !settings.CaptureStats {
+		t.Error("got capture_stats false, want the second file's true")
+	}
+	if settings.StatusFile != "status.json" {
+		t.Errorf("got status_file %q, want the third file's", settings.StatusFile)
+	}
+}
+
+func TestStripJSONComments(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "no comments", input: `{"a": 1}`, want: `{"a": 1}`},
+		{name: "whole line", input: "// settings\n{}", want: "           \n{}"},
+		{name: "end of line", input: "{\"a\": 1} // one\n", want: "{\"a\": 1}       \n"},
+		{name: "last line without newline", input: "{} // done", want: "{}        "},
+		{name: "slashes in a string", input: `{"url": "http://example.com"}`, want: `{"url": "http://example.com"}`},
+		{name: "escaped quote in a string", input: `{"a": "x\"//y"} // z`, want: `{"a": "x\"//y"}     `},
+		{name: "quote in a comment", input: "// \"\n{}", want: "    \n{}"},
+		{name: "single slash", input: `{"a": 1 /}`, want: `{"a": 1 /}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := []byte(test.input)
+			if got := stripJSONComments(data); string(got) != test.want {
+				t.Errorf("stripJSONComments(%q) = %q, want %q", test.input, got, test.want)
+			}
+			if string(data) != test.input {
+				t.Errorf("stripJSONComments() changed its input to %q", data)
+			}
+		})
+	}
+}
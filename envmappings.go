@@ -0,0 +1,67 @@
+package autorsync
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var envMappingPattern = regexp.MustCompile(`^AUTORSYNC_MAPPING_(\d+)_([A-Z0-9_]+)$`)
+
+// Build mappings from AUTORSYNC_MAPPING_<N>_<KEY> variables in environ, where KEY is the
+// upper-cased name of a mapping field in the config file (e.g. AUTORSYNC_MAPPING_0_SOURCE).
+// The mappings are returned in order of N, with values parsed as for applyEnvOverrides.
+func mappingsFromEnv(environ []string) ([]*Mapping, error) {
+	fields := make(map[string]int)
+	t := reflect.TypeOf(Mapping{})
+	for i := 0; i < t.NumField(); i++ {
+		if key := t.Field(i).Tag.Get("yaml"); key != "" && key != "-" {
+			fields[strings.ToUpper(key)] = i
+		}
+	}
+
+	byIndex := make(map[int]*Mapping)
+	for _, entry := range environ {
+		name, value := entry, ""
+		if eq := strings.Index(entry, "="); eq >= 0 {
+			name, value = entry[:eq], entry[eq+1:]
+		}
+		match := envMappingPattern.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+
+		index, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid mapping number in %s", name)
+		}
+		field, ok := fields[match[2]]
+		if !ok {
+			return nil, fmt.Errorf("unknown mapping setting %s", name)
+		}
+
+		mapping, ok := byIndex[index]
+		if !ok {
+			mapping = &Mapping{}
+			byIndex[index] = mapping
+		}
+		if err := setFromEnv(reflect.ValueOf(mapping).Elem().Field(field), value); err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+	}
+
+	indexes := make([]int, 0, len(byIndex))
+	for index := range byIndex {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	mappings := make([]*Mapping, 0, len(indexes))
+	for _, index := range indexes {
+		mappings = append(mappings, byIndex[index])
+	}
+	return mappings, nil
+}
@@ -0,0 +1,88 @@
+package autorsync
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMappingsFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		environ []string
+		want    []*Mapping
+		wantErr string
+	}{
+		{
+			name:    "no mappings",
+			environ: []string{"HOME=/home/u", "AUTORSYNC_INTERVAL=5s"},
+			want:    []*Mapping{},
+		},
+		{
+			name: "ordered by number",
+			environ: []string{
+				"AUTORSYNC_MAPPING_10_SOURCE=/b", "AUTORSYNC_MAPPING_10_TARGET=host:/b",
+				"AUTORSYNC_MAPPING_2_SOURCE=/a", "AUTORSYNC_MAPPING_2_TARGET=host:/a",
+			},
+			want: []*Mapping{
+				{Sources: sourceList{"/a"}, Target: "host:/a"},
+				{Sources: sourceList{"/b"}, Target: "host:/b"},
+			},
+		},
+		{
+			name:    "source with spaces",
+			environ: []string{"AUTORSYNC_MAPPING_0_SOURCE=/home/u/My Documents", "AUTORSYNC_MAPPING_0_TARGET=/backup"},
+			want:    []*Mapping{{Sources: sourceList{"/home/u/My Documents"}, Target: "/backup"}},
+		},
+		{
+			name:    "several sources",
+			environ: []string{"AUTORSYNC_MAPPING_0_SOURCE=/src/a" + string(os.PathListSeparator) + "/src/b c", "AUTORSYNC_MAPPING_0_TARGET=/backup"},
+			want:    []*Mapping{{Sources: sourceList{"/src/a", "/src/b c"}, Target: "/backup"}},
+		},
+		{
+			name: "typed fields",
+			environ: []string{
+				"AUTORSYNC_MAPPING_0_SOURCE=/src", "AUTORSYNC_MAPPING_0_TARGET=/backup",
+				"AUTORSYNC_MAPPING_0_EXCLUSIONS=.git  node_modules", "AUTORSYNC_MAPPING_0_MAX_SYNC_FILES=100",
+				"AUTORSYNC_MAPPING_0_DELETE_EXTRANEOUS=true", "AUTORSYNC_MAPPING_0_ENABLED=0",
+			},
+			want: []*Mapping{{
+				Sources: sourceList{"/src"}, Target: "/backup", Exclusions: []string{".git", "node_modules"},
+				MaxSyncFiles: 100, DeleteExtraneous: true, Enabled: boolPtr(false),
+			}},
+		},
+		{
+			name:    "unknown key",
+			environ: []string{"AUTORSYNC_MAPPING_0_SORUCE=/src"},
+			wantErr: "unknown mapping setting AUTORSYNC_MAPPING_0_SORUCE",
+		},
+		{
+			name:    "invalid number",
+			environ: []string{"AUTORSYNC_MAPPING_0_MAX_SYNC_FILES=lots"},
+			wantErr: "invalid value for AUTORSYNC_MAPPING_0_MAX_SYNC_FILES",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := mappingsFromEnv(test.environ)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("mappingsFromEnv() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("mappingsFromEnv() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("mappingsFromEnv() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,61 @@
+package autorsync
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// A line in the event log for a single run of rsync.
+type eventLogRecord struct {
+	StartedAt time.Time `json:"started_at"`
+	Name      string    `json:"name"`
+	Source    string    `json:"source"`
+	Target    string    `json:"target"`
+	// In seconds, to keep the log easy to process.
+	Duration float64 `json:"duration"`
+	Success  bool    `json:"success"`
+	ExitCode int     `json:"exit_code"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Append a record of a run of rsync for mapping to settings.event_log_file. The file is opened
+// for each record so that it can be rotated or removed while autorsync is running.
+func (s *Syncer) logSyncEvent(mapping *Mapping, startedAt time.Time, duration time.Duration, err error) {
+	path := s.config.Settings.EventLogFile
+	if path == "" {
+		return
+	}
+
+	record := eventLogRecord{
+		StartedAt: startedAt,
+		Name:      mapping.label(),
+		Source:    mapping.Source,
+		Target:    mapping.syncTarget,
+		Duration:  duration.Seconds(),
+		Success:   err == nil,
+		ExitCode:  exitCode(err),
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+
+	s.eventLogMutex.Lock()
+	defer s.eventLogMutex.Unlock()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		mappingLog(mapping).Errorf("failed to open event log: %v", err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		mappingLog(mapping).Errorf("failed to write event log: %v", err)
+	}
+}
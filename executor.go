@@ -0,0 +1,47 @@
+package autorsync
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+)
+
+// An executor runs a command to completion and returns what it wrote to stdout. If the
+// command exits unsuccessfully the error is an *exec.ExitError with Stderr filled in. The
+// Syncer runs rsync through one so that tests can swap in a fake.
+type executor func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+// The default executor. The command runs in its own process group, and the whole group is
+// killed if ctx is done before it finishes; CommandContext on its own would only kill rsync,
+// leaving its ssh child holding the output pipes open. (Cmd.Cancel would cover this, but it
+// needs Go 1.20.)
+func runCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	setProcessGroup(cmd)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd)
+		case <-finished:
+		}
+	}()
+
+	err := cmd.Wait()
+	close(finished)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		exitErr.Stderr = stderr.Bytes()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
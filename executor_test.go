@@ -0,0 +1,90 @@
+//go:build !windows
+// +build !windows
+
+package autorsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Write a shell script standing in for rsync and return its path.
+func writeScript(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rsync")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunCommandOutput(t *testing.T) {
+	script := writeScript(t, `echo "$@"; echo ignored >&2`)
+	output, err := runCommand(context.Background(), script, "-a", "src", "dst")
+	if err != nil {
+		t.Fatalf("runCommand() error = %v", err)
+	}
+	if string(output) != "-a src dst\n" {
+		t.Errorf("runCommand() = %q, want only stdout", output)
+	}
+}
+
+func TestRunCommandExitError(t *testing.T) {
+	script := writeScript(t, "echo partial; echo oops >&2; exit 23")
+	_, err := runCommand(context.Background(), script)
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("runCommand() error = %v, want an *exec.ExitError", err)
+	}
+	if exitErr.ExitCode() != 23 {
+		t.Errorf("exit code = %d, want 23", exitErr.ExitCode())
+	}
+	if !bytes.Equal(exitErr.Stderr, []byte("oops\n")) {
+		t.Errorf("Stderr = %q, want %q", exitErr.Stderr, "oops\n")
+	}
+}
+
+// rsync over ssh leaves a child holding its output pipes, which runCommand waits on, so it
+// only returns promptly if cancelling killed the whole process group.
+func TestRunCommandCancelKillsProcessGroup(t *testing.T) {
+	script := writeScript(t, "sleep 30 & wait")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := runCommand(ctx, script); err == nil {
+		t.Fatal("runCommand() succeeded, want it to be killed")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("runCommand() took %s to return after being cancelled", elapsed)
+	}
+}
+
+func TestRunCommandCancelledBeforeStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := runCommand(ctx, writeScript(t, "echo ran")); err == nil {
+		t.Error("runCommand() succeeded with a cancelled context")
+	}
+}
+
+func TestRunRsyncTimeoutKillsRsync(t *testing.T) {
+	mapping := &Mapping{Source: t.TempDir(), Target: "/backup", RsyncBinary: writeScript(t, "sleep 30")}
+	s := newTestSyncer(t, &Settings{}, Options{RsyncTimeout: 100 * time.Millisecond}, mapping, nil)
+
+	start := time.Now()
+	_, _, err := s.runRsync(context.Background(), mapping)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("runRsync() error = %v, want it to time out", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("runRsync() took %s with a 100ms timeout", elapsed)
+	}
+}
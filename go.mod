@@ -1,6 +1,12 @@
 module github.com/dcrodman/autorsync
 
-go 1.14
+go 1.16
 
-require github.com/fsnotify/fsnotify v1.4.9
- 
\ No newline at end of file
+require (
+	github.com/BurntSushi/toml v0.3.1
+	github.com/fsnotify/fsnotify v1.4.9
+	github.com/gdamore/tcell/v2 v2.3.3
+	github.com/prometheus/client_golang v1.11.1
+	github.com/rivo/tview v0.0.0-20210624165335-29d673af0ce2
+	gopkg.in/yaml.v2 v2.4.0
+)
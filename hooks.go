@@ -0,0 +1,26 @@
+package autorsync
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Run one of mapping's pre_sync or post_sync commands with sh -c, logging its output.
+func (s *Syncer) runHook(ctx context.Context, mapping *Mapping, name, command string) error {
+	mappingLog(mapping).Infof("running %s for %s: %s", name, mapping.label(), command)
+	if s.options.DryRun {
+		return nil
+	}
+
+	output, err := exec.CommandContext(ctx, "sh", "-c", command).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s for %s failed: %w: %s", name, mapping.label(), err, strings.TrimSpace(string(output)))
+	}
+
+	if len(output) > 0 {
+		mappingLog(mapping).Infof("%s", output)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package autorsync
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// A single pattern from a .gitignore-style file.
+type ignoreRule struct {
+	// Directory containing the ignore file, relative to the mapping source.
+	base    string
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Read the rules from an ignore file in base (relative to the mapping source). Missing
+// files have no rules.
+func readIgnoreFile(path, base string) ([]ignoreRule, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(scanner.Text(), base); ok {
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules, scanner.Err()
+}
+
+// Read the exclusions listed in a .autorsyncignore file, one per line. Blank lines and lines
+// starting with # are skipped. Missing files have no exclusions.
+func readExclusionsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var exclusions []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			exclusions = append(exclusions, line)
+		}
+	}
+
+	return exclusions, scanner.Err()
+}
+
+// Convert a line from an ignore file into a rule, following the gitignore pattern format.
+func parseIgnoreRule(line, base string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{base: filepath.ToSlash(base)}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+
+	// Patterns with a slash anywhere but the end are relative to the ignore file's directory,
+	// everything else can match at any depth.
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	if !anchored {
+		expr.WriteString("(.*/)?")
+	}
+
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case strings.HasPrefix(line[i:], "**/"):
+			expr.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(line[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(line[i+1:], ']')
+			if end < 0 {
+				expr.WriteString(`\[`)
+				continue
+			}
+			class := line[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(line):
+			i++
+			expr.WriteString(regexp.QuoteMeta(line[i : i+1]))
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return ignoreRule{}, false
+	}
+	rule.re = re
+
+	return rule, true
+}
+
+// Report whether relPath (relative to the mapping source) is ignored by rules. As with git,
+// a path is ignored if any of its parent directories are.
+func isIgnored(rules []ignoreRule, relPath string, isDir bool) bool {
+	if len(rules) == 0 {
+		return false
+	}
+
+	components := strings.Split(filepath.ToSlash(relPath), "/")
+	for i := range components {
+		last := i == len(components)-1
+		if matchIgnoreRules(rules, strings.Join(components[:i+1], "/"), !last || isDir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The last rule that matches path decides whether it's ignored.
+func matchIgnoreRules(rules []ignoreRule, path string, isDir bool) bool {
+	ignored := false
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+
+		rel := path
+		if rule.base != "." && rule.base != "" {
+			if !strings.HasPrefix(path, rule.base+"/") {
+				continue
+			}
+			rel = strings.TrimPrefix(path, rule.base+"/")
+		}
+
+		if rule.re.MatchString(rel) {
+			ignored = !rule.negate
+		}
+	}
+
+	return ignored
+}
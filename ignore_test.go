@@ -0,0 +1,99 @@
+package autorsync
+
+import "testing"
+
+func TestParseIgnoreRuleSkipsLines(t *testing.T) {
+	for _, line := range []string{"", "   ", "# a comment", "\t\r"} {
+		if _, ok := parseIgnoreRule(line, "."); ok {
+			t.Errorf("parseIgnoreRule(%q) made a rule, want it skipped", line)
+		}
+	}
+}
+
+func TestIsIgnored(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{name: "no rules", path: "main.go", want: false},
+		{name: "name at any depth", lines: []string{"*.log"}, path: "a/b/debug.log", want: true},
+		{name: "name not matched", lines: []string{"*.log"}, path: "a/b/debug.txt", want: false},
+		{name: "star doesn't cross slashes", lines: []string{"a/*.go"}, path: "a/b/main.go", want: false},
+		{name: "question mark", lines: []string{"file?.txt"}, path: "file1.txt", want: true},
+		{name: "question mark needs a character", lines: []string{"file?.txt"}, path: "file.txt", want: false},
+		{name: "anchored by a leading slash", lines: []string{"/build"}, path: "build", isDir: true, want: true},
+		{name: "leading slash only matches the top", lines: []string{"/build"}, path: "src/build", isDir: true, want: false},
+		{name: "anchored by a middle slash", lines: []string{"docs/*.md"}, path: "docs/README.md", want: true},
+		{name: "middle slash only matches the top", lines: []string{"docs/*.md"}, path: "src/docs/README.md", want: false},
+		{name: "leading double star", lines: []string{"**/testdata"}, path: "a/b/testdata", isDir: true, want: true},
+		{name: "leading double star at the top", lines: []string{"**/testdata"}, path: "testdata", isDir: true, want: true},
+		{name: "middle double star", lines: []string{"a/**/z"}, path: "a/b/c/z", want: true},
+		{name: "middle double star with no directories", lines: []string{"a/**/z"}, path: "a/z", want: true},
+		{name: "trailing double star", lines: []string{"vendor/**"}, path: "vendor/x/y.go", want: true},
+		{name: "character class", lines: []string{"*.[oa]"}, path: "lib.a", want: true},
+		{name: "character class not matched", lines: []string{"*.[oa]"}, path: "lib.c", want: false},
+		{name: "negated character class", lines: []string{"file[!0-9]"}, path: "filex", want: true},
+		{name: "negated character class not matched", lines: []string{"file[!0-9]"}, path: "file1", want: false},
+		{name: "unclosed bracket is literal", lines: []string{"a[b"}, path: "a[b", want: true},
+		{name: "escaped star is literal", lines: []string{`\*.txt`}, path: "*.txt", want: true},
+		{name: "escaped star doesn't match others", lines: []string{`\*.txt`}, path: "a.txt", want: false},
+		{name: "escaped hash", lines: []string{`\#notes`}, path: "#notes", want: true},
+		{name: "escaped bang", lines: []string{`\!important`}, path: "!important", want: true},
+		{name: "trailing spaces trimmed", lines: []string{"*.tmp   "}, path: "x.tmp", want: true},
+		{name: "dot is literal", lines: []string{"a.b"}, path: "axb", want: false},
+		{name: "dir-only rule matches a directory", lines: []string{"out/"}, path: "out", isDir: true, want: true},
+		{name: "dir-only rule skips a file", lines: []string{"out/"}, path: "out", want: false},
+		{name: "dir-only rule covers files inside", lines: []string{"out/"}, path: "out/app.bin", want: true},
+		{name: "negation re-includes", lines: []string{"*.log", "!keep.log"}, path: "keep.log", want: false},
+		{name: "last matching rule wins", lines: []string{"!keep.log", "*.log"}, path: "keep.log", want: true},
+		{name: "negation can't re-include inside an ignored directory", lines: []string{"build/", "!build/keep"},
+			path: "build/keep", want: true},
+		{name: "parent directory ignored", lines: []string{"node_modules"}, path: "node_modules/x/index.js", want: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var rules []ignoreRule
+			for _, line := range test.lines {
+				rule, ok := parseIgnoreRule(line, ".")
+				if !ok {
+					t.Fatalf("parseIgnoreRule(%q) made no rule", line)
+				}
+				rules = append(rules, rule)
+			}
+			if got := isIgnored(rules, test.path, test.isDir); got != test.want {
+				t.Errorf("isIgnored(%q, %q) = %v, want %v", test.lines, test.path, got, test.want)
+			}
+		})
+	}
+}
+
+func TestIsIgnoredInSubdirectory(t *testing.T) {
+	// Rules from src/.gitignore only apply under src.
+	var rules []ignoreRule
+	for _, line := range []string{"*.gen.go", "/local"} {
+		rule, _ := parseIgnoreRule(line, "src")
+		rules = append(rules, rule)
+	}
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{path: "src/api.gen.go", want: true},
+		{path: "src/pkg/api.gen.go", want: true},
+		{path: "api.gen.go", want: false},
+		{path: "src/local", isDir: true, want: true},
+		{path: "src/pkg/local", isDir: true, want: false},
+		{path: "local", isDir: true, want: false},
+	}
+	for _, test := range tests {
+		if got := isIgnored(rules, test.path, test.isDir); got != test.want {
+			t.Errorf("isIgnored(%q) = %v, want %v", test.path, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// ANSI color codes for the text mode prefixes.
+var prefixColors = []struct {
+	prefix []byte
+	color  string
+}{
+	{[]byte("[error] "), "\x1b[31m"},
+	{[]byte("[warn] "), "\x1b[33m"},
+	{[]byte("[event] "), "\x1b[36m"},
+	{[]byte("[rsync] "), "\x1b[37m"},
+}
+
+const colorReset = "\x1b[0m"
+
+// Colors each line written through it by the prefix after the log package's timestamp.
+// The log package writes one whole line at a time.
+type colorWriter struct {
+	w io.Writer
+}
+
+func (cw colorWriter) Write(p []byte) (int, error) {
+	n := headerLen(log.Flags())
+	if len(p) < n {
+		return cw.w.Write(p)
+	}
+	line := p[n:]
+	for _, pc := range prefixColors {
+		if !bytes.HasPrefix(line, pc.prefix) {
+			continue
+		}
+		colored := make([]byte, 0, len(p)+len(pc.color)+len(colorReset))
+		colored = append(colored, pc.color...)
+		colored = append(colored, bytes.TrimSuffix(p, []byte("\n"))...)
+		colored = append(colored, colorReset+"\n"...)
+		if _, err := cw.w.Write(colored); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	return cw.w.Write(p)
+}
+
+// The length of the timestamp that the log package puts before each message.
+func headerLen(flags int) int {
+	n := 0
+	if flags&log.Ldate != 0 {
+		n += len("2006/01/02 ")
+	}
+	if flags&(log.Ltime|log.Lmicroseconds) != 0 {
+		n += len("15:04:05 ")
+		if flags&log.Lmicroseconds != 0 {
+			n += len(".000000")
+		}
+	}
+	return n
+}
+
+// SetColor colors errors, warnings, change events and rsync's output in text output: always, never, or with
+// auto only when writing to a terminal. Should be called after SetFormat and SetOutputFile.
+func SetColor(mode string) error {
+	switch mode {
+	case "never":
+		return nil
+	case "auto":
+		if !isTerminal(log.Writer()) {
+			return nil
+		}
+	case "always":
+	default:
+		return fmt.Errorf("invalid color mode: %s", mode)
+	}
+
+	// JSON lines are for other programs, which won't want escape codes in them.
+	if format == "text" {
+		log.SetOutput(colorWriter{log.Writer()})
+	}
+	return nil
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,118 @@
+package logging
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The suffix added to the names of rotated log files.
+const backupTimeFormat = "20060102T150405.000"
+
+// A log file that's renamed aside with a timestamp suffix and replaced with a new one once it
+// grows past maxSize bytes.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	size int64
+}
+
+// SetOutputFile appends log output to the file at path instead of stderr. When maxSize is
+// positive the file is rotated once writing to it would take it past maxSize bytes, keeping
+// at most maxBackups of the old files (or all of them when maxBackups is 0).
+func SetOutputFile(path string, maxSize int64, maxBackups int) error {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	log.SetOutput(f)
+	return nil
+}
+
+// Only called by the log package, which holds its mutex while writing, so rotation can't
+// race with another write.
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			// Keep logging to the current file rather than losing messages.
+			fmt.Fprintf(os.Stderr, "failed to rotate log file: %v\n", err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *rotatingFile) rotate() error {
+	backup := f.path + "." + time.Now().Format(backupTimeFormat)
+	if err := os.Rename(f.path, backup); err != nil {
+		return err
+	}
+
+	old := f.file
+	if err := f.open(); err != nil {
+		return err
+	}
+	old.Close()
+
+	return f.removeOldBackups()
+}
+
+func (f *rotatingFile) removeOldBackups() error {
+	if f.maxBackups <= 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(f.path))
+	if err != nil {
+		return err
+	}
+	// Other files named after the log file, like app.log.bak, aren't backups of it.
+	var backups []string
+	prefix := filepath.Base(f.path) + "."
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if _, err := time.Parse(backupTimeFormat, name[len(prefix):]); err == nil {
+			backups = append(backups, filepath.Join(filepath.Dir(f.path), name))
+		}
+	}
+	// The timestamp suffixes sort in the order the backups were made.
+	sort.Strings(backups)
+
+	for len(backups) > f.maxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
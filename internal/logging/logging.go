@@ -0,0 +1,164 @@
+// Package logging writes autorsync's log messages, either as plain text or as JSON.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var format = "text"
+
+// Messages below the level set by SetLevel are dropped. Change events count as info.
+var levels = map[string]int32{"debug": 0, "info": 1, "warn": 2, "error": 3, "fatal": 4}
+
+var (
+	// The level and quiet state set by SetLevel and SetQuiet, which together decide minLevel.
+	levelMutex sync.Mutex
+	level      = levels["info"]
+	quiet      bool
+	// Read with atomic.LoadInt32, since the level can change while other goroutines log.
+	minLevel = levels["info"]
+)
+
+// Extra context attached to a log message. Only included in JSON output.
+type fields struct {
+	MappingName   string `json:"mapping_name,omitempty"`
+	MappingSource string `json:"mapping_source,omitempty"`
+	MappingTarget string `json:"mapping_target,omitempty"`
+	EventPath     string `json:"event_path,omitempty"`
+}
+
+type Logger struct {
+	fields fields
+}
+
+// Log messages about the mapping from source to target with its details attached. name may
+// be empty.
+func ForMapping(name, source, target string) Logger {
+	return Logger{fields{MappingName: name, MappingSource: source, MappingTarget: target}}
+}
+
+func (l Logger) WithEventPath(path string) Logger {
+	l.fields.EventPath = path
+	return l
+}
+
+// Detail that's only useful when tracking down a problem.
+func (l Logger) Debugf(format string, v ...interface{}) {
+	l.output("debug", "[debug] ", fmt.Sprintf(format, v...))
+}
+
+func (l Logger) Infof(format string, v ...interface{}) {
+	l.output("info", "", fmt.Sprintf(format, v...))
+}
+
+// File change events are logged at info level but keep their own prefix in text mode.
+func (l Logger) Eventf(format string, v ...interface{}) {
+	l.output("info", "[event] ", fmt.Sprintf(format, v...))
+}
+
+// What rsync printed, logged at info level with its own prefix in text mode.
+func (l Logger) Outputf(format string, v ...interface{}) {
+	l.output("info", "[rsync] ", fmt.Sprintf(format, v...))
+}
+
+func (l Logger) Warnf(format string, v ...interface{}) {
+	l.output("warn", "[warn] ", fmt.Sprintf(format, v...))
+}
+
+func (l Logger) Errorf(format string, v ...interface{}) {
+	l.output("error", "[error] ", fmt.Sprintf(format, v...))
+}
+
+func (l Logger) Fatalf(format string, v ...interface{}) {
+	l.output("fatal", "", fmt.Sprintf(format, v...))
+	os.Exit(1)
+}
+
+func (l Logger) output(level, prefix, msg string) {
+	if levels[level] < atomic.LoadInt32(&minLevel) {
+		return
+	}
+	if format != "json" {
+		log.Print(prefix + msg)
+		return
+	}
+
+	entry, _ := json.Marshal(struct {
+		Time  string `json:"time"`
+		Level string `json:"level"`
+		Msg   string `json:"msg"`
+		fields
+	}{time.Now().Format(time.RFC3339Nano), level, msg, l.fields})
+	log.Print(string(entry))
+}
+
+func Debugf(format string, v ...interface{}) { Logger{}.Debugf(format, v...) }
+func Infof(format string, v ...interface{})  { Logger{}.Infof(format, v...) }
+func Eventf(format string, v ...interface{}) { Logger{}.Eventf(format, v...) }
+func Warnf(format string, v ...interface{})  { Logger{}.Warnf(format, v...) }
+func Errorf(format string, v ...interface{}) { Logger{}.Errorf(format, v...) }
+func Fatalf(format string, v ...interface{}) { Logger{}.Fatalf(format, v...) }
+
+// SetQuiet drops everything but errors when quiet is true, whatever SetLevel was given, and
+// goes back to that level otherwise.
+func SetQuiet(q bool) {
+	levelMutex.Lock()
+	defer levelMutex.Unlock()
+	quiet = q
+	updateMinLevel()
+}
+
+// SetLevel drops messages below name: debug, info, warn or error. It can be called again at
+// any time, as it is when the config is reloaded, but has no effect while SetQuiet is on.
+// Text output gets microsecond timestamps at the debug level. Should be called after
+// SetFormat.
+func SetLevel(name string) error {
+	n, ok := levels[name]
+	if !ok || name == "fatal" {
+		return fmt.Errorf("invalid log level: %s", name)
+	}
+
+	levelMutex.Lock()
+	defer levelMutex.Unlock()
+	level = n
+	updateMinLevel()
+	return nil
+}
+
+// Called with levelMutex held.
+func updateMinLevel() {
+	n := level
+	if quiet {
+		n = levels["error"]
+	}
+	atomic.StoreInt32(&minLevel, n)
+
+	if format == "text" {
+		if n == levels["debug"] {
+			log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+		} else {
+			log.SetFlags(log.LstdFlags)
+		}
+	}
+}
+
+// Configure the log package for the given output format, text or json. Should be called
+// before anything is logged.
+func SetFormat(f string) error {
+	switch f {
+	case "text":
+	case "json":
+		// Each entry carries its own timestamp.
+		log.SetFlags(0)
+	default:
+		return fmt.Errorf("invalid log format: %s", f)
+	}
+	format = f
+	return nil
+}
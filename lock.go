@@ -0,0 +1,68 @@
+package autorsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Returned instead of syncing when another process holds the mapping's lock for longer than
+// settings.lock_timeout.
+var errMappingLocked = errors.New("mapping is locked by another process")
+
+// How often to try for a lock that's held by someone else.
+const lockRetryInterval = 100 * time.Millisecond
+
+// The lock file for mapping, shared by every autorsync process syncing the same source to
+// the same target.
+func lockPath(mapping *Mapping) string {
+	sum := sha256.Sum256([]byte(mapping.Source + "\x00" + mapping.Target))
+	return filepath.Join(os.TempDir(), "autorsync-"+hex.EncodeToString(sum[:8])+".lock")
+}
+
+// Take mapping's lock, waiting up to timeout for another process to release it, and return
+// a function that releases it. If the lock file can't be used at all the mapping is synced
+// without it.
+func lockMapping(ctx context.Context, mapping *Mapping, timeout time.Duration) (func(), error) {
+	path := lockPath(mapping)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		mappingLog(mapping).Warnf("syncing %s without a lock: %v", mapping.label(), err)
+		return func() {}, nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			mappingLog(mapping).Warnf("syncing %s without a lock: %v", mapping.label(), err)
+			return func() {}, nil
+		}
+		if locked {
+			mappingLog(mapping).Debugf("locked %s", path)
+			return func() {
+				unlock(f)
+				f.Close()
+				mappingLog(mapping).Debugf("unlocked %s", path)
+			}, nil
+		}
+
+		if !time.Now().Before(deadline) {
+			f.Close()
+			mappingLog(mapping).Warnf("not syncing %s: another process still holds %s after %s",
+				mapping.label(), path, timeout)
+			return nil, errMappingLocked
+		}
+		select {
+		case <-time.After(lockRetryInterval):
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package autorsync
+
+import (
+	"os"
+	"syscall"
+)
+
+// Take an exclusive flock on f without blocking, reporting whether it was free.
+func tryLock(f *os.File) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func unlock(f *os.File) {
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
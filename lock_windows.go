@@ -0,0 +1,10 @@
+package autorsync
+
+import "os"
+
+// Windows has no flock, so mappings aren't locked there.
+func tryLock(f *os.File) (bool, error) {
+	return true, nil
+}
+
+func unlock(f *os.File) {}
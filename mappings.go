@@ -0,0 +1,188 @@
+package autorsync
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A request from AddMapping or RemoveMapping, or to start watching a mapping whose missing
+// source has been created, carried out by the event loop since it owns the watches.
+type mappingChange struct {
+	add     *Mapping
+	remove  string
+	created *Mapping
+	result  chan error
+}
+
+var errNotRunning = errors.New("syncer isn't running")
+
+// A SyncManager changes which mappings a running Syncer watches, for code like an API or a
+// dashboard that shouldn't be handed the whole Syncer. Its methods are safe to call from any
+// goroutine.
+type SyncManager struct {
+	syncer *Syncer
+}
+
+// Manager returns a SyncManager for the Syncer's mappings.
+func (s *Syncer) Manager() *SyncManager {
+	return &SyncManager{syncer: s}
+}
+
+// Add is the same as Syncer.AddMapping.
+func (m *SyncManager) Add(mapping *Mapping) error {
+	return m.syncer.AddMapping(mapping)
+}
+
+// Remove is the same as Syncer.RemoveMapping.
+func (m *SyncManager) Remove(source string) error {
+	return m.syncer.RemoveMapping(source)
+}
+
+// AddMapping starts watching and syncing mapping while the Syncer is running. mapping is
+// prepared like one read from the config file, so its Source, Target and other configured
+// fields should be set but not its Sources. Mappings added this way aren't in the config
+// file, so they're dropped if it's reloaded.
+func (s *Syncer) AddMapping(mapping *Mapping) error {
+	return s.changeMappings(mappingChange{add: mapping})
+}
+
+// RemoveMapping stops watching and syncing every mapping with the given source while the
+// Syncer is running. Pending changes to them are dropped.
+func (s *Syncer) RemoveMapping(source string) error {
+	source, err := expandPath(source)
+	if err != nil {
+		return err
+	}
+	return s.changeMappings(mappingChange{remove: source})
+}
+
+func (s *Syncer) changeMappings(change mappingChange) error {
+	if s.watcher == nil {
+		return errNotRunning
+	}
+
+	change.result = make(chan error, 1)
+	select {
+	case s.changes <- change:
+	case <-s.eventsDone:
+		return errNotRunning
+	}
+	return <-change.result
+}
+
+// Validate mapping and start watching it. Only called from the event loop.
+func (s *Syncer) addMapping(mapping *Mapping) error {
+	if mapping.Source == "" {
+		return errors.New("mapping has no source")
+	}
+	mapping.Sources = sourceList{mapping.Source}
+	if err := prepareMapping(s.config.Settings, mapping, s.options.ConfigFiles); err != nil {
+		return err
+	}
+
+	s.syncMutex.Lock()
+	defer s.syncMutex.Unlock()
+	s.needsRsyncMutex.Lock()
+	defer s.needsRsyncMutex.Unlock()
+
+	for _, existing := range s.config.Mappings {
+		if mapping.Name != "" && existing.Name == mapping.Name {
+			return fmt.Errorf("mapping name %s is already used", mapping.Name)
+		}
+		if existing.Source == mapping.Source && existing.Target == mapping.Target {
+			return fmt.Errorf("%s is already mapped to %s", mapping.Source, mapping.Target)
+		}
+	}
+
+	logMappingStart(mapping)
+	if err := s.watchOrPoll(s.watcher, mapping); err != nil {
+		return err
+	}
+	s.needsRsync[mapping] = false
+	s.config.Mappings = append(s.config.Mappings, mapping)
+	s.saveState()
+	return nil
+}
+
+// Start watching a mapping whose source was missing and sync it. Only called from the event
+// loop.
+func (s *Syncer) watchCreatedSource(mapping *Mapping) error {
+	s.needsRsyncMutex.Lock()
+	defer s.needsRsyncMutex.Unlock()
+
+	// The mapping may have been removed since its source was found.
+	if _, ok := s.needsRsync[mapping]; !ok || !mapping.missing {
+		return nil
+	}
+	mappingLog(mapping).Infof("%s has been created, syncing %s", mapping.Source, mapping.label())
+	if err := s.watchOrPoll(s.watcher, mapping); err != nil {
+		return err
+	}
+	s.setNeedsRsync(mapping)
+	return nil
+}
+
+// Handle a directory source being deleted or moved away, as build tools that rm -rf and
+// recreate their output do. Its watches go with it, so they're dropped and the source is
+// either watched again straight away if it's already back, or marked as missing until
+// pollForChanges sees it reappear. Only called from the event loop.
+func (s *Syncer) checkSourceRemoved(path string) {
+	s.needsRsyncMutex.Lock()
+	defer s.needsRsyncMutex.Unlock()
+
+	for _, mapping := range s.config.Mappings {
+		// Single-file sources get their watch back in handleEvent when they're replaced.
+		if mapping.Source != path || mapping.polling || mapping.missing || isRegularFile(path) {
+			continue
+		}
+		unwatchRemovedSource(s.watcher, s.watched, path)
+		if isDirectory(path) {
+			mappingLog(mapping).Infof("%s was replaced, watching it again", path)
+			if err := watchFilesInDirectory(s.watcher, s.watched, mapping, path); err != nil {
+				mappingLog(mapping).Errorf("failed to watch %s: %v", path, err)
+			}
+			continue
+		}
+		mappingLog(mapping).Warnf("%s was removed, waiting for it to come back", path)
+		mapping.missing = true
+		mapping.sourceGone = true
+		s.needsRsync[mapping] = false
+	}
+}
+
+// Stop watching the mappings with the given source and return them. Only called from the
+// event loop.
+func (s *Syncer) removeMappings(source string) ([]*Mapping, error) {
+	s.syncMutex.Lock()
+	defer s.syncMutex.Unlock()
+	s.needsRsyncMutex.Lock()
+	defer s.needsRsyncMutex.Unlock()
+
+	var mappings, removed []*Mapping
+	for _, mapping := range s.config.Mappings {
+		if mapping.Source == source {
+			mappingLog(mapping).Infof("no longer syncing %s", mapping.label())
+			delete(s.needsRsync, mapping)
+			removed = append(removed, mapping)
+		} else {
+			mappings = append(mappings, mapping)
+		}
+	}
+	if len(removed) == 0 {
+		return nil, fmt.Errorf("no mapping has source %s", source)
+	}
+
+	unwatchFilesInDirectory(s.watcher, s.watched, source)
+	// Nested sources share watches, so put back any that the others still need.
+	for _, mapping := range mappings {
+		if !mapping.polling && (isWithin(mapping.Source, source) || isWithin(source, mapping.Source)) {
+			if err := watchFilesInDirectory(s.watcher, s.watched, mapping, mapping.Source); err != nil {
+				mappingLog(mapping).Errorf("%v", err)
+			}
+		}
+	}
+
+	s.config.Mappings = mappings
+	s.saveState()
+	return removed, nil
+}
@@ -0,0 +1,40 @@
+package autorsync
+
+import "syscall"
+
+// Filesystem type names from statfs(2) for network filesystems that kqueue can't see remote
+// changes on.
+var networkFilesystems = map[string]bool{
+	"nfs":    true,
+	"smbfs":  true,
+	"afpfs":  true,
+	"webdav": true,
+	"cifs":   true,
+	"ftp":    true,
+}
+
+// Return the type of network filesystem that path is on, or an empty string if it's local.
+func networkFilesystem(path string) string {
+	if name := filesystemType(path); networkFilesystems[name] {
+		return name
+	}
+	return ""
+}
+
+// Return the name of the filesystem that path is on, or an empty string if path can't be
+// checked.
+func filesystemType(path string) string {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return ""
+	}
+
+	var name []byte
+	for _, c := range fs.Fstypename {
+		if c == 0 {
+			break
+		}
+		name = append(name, byte(c))
+	}
+	return string(name)
+}
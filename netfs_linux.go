@@ -0,0 +1,55 @@
+package autorsync
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Filesystem magic numbers from statfs(2) for network filesystems that inotify can't see
+// remote changes on.
+var networkFilesystems = map[uint32]string{
+	0x6969:     "nfs",
+	0x517b:     "smb",
+	0xff534d42: "cifs",
+	0xfe534d42: "smb2",
+	0x564c:     "ncp",
+	0x7461636f: "ocfs2",
+	0x47504653: "gpfs",
+	0x00c36400: "ceph",
+}
+
+// Names for other common filesystem magic numbers, for log messages.
+var filesystemNames = map[uint32]string{
+	0xef53:     "ext4",
+	0x9123683e: "btrfs",
+	0x58465342: "xfs",
+	0x01021994: "tmpfs",
+	0x65735546: "fuse",
+	0x794c7630: "overlayfs",
+}
+
+// Return the type of network filesystem that path is on, or an empty string if it's local.
+func networkFilesystem(path string) string {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return ""
+	}
+	return networkFilesystems[uint32(fs.Type)]
+}
+
+// Return the name of the filesystem that path is on, or its magic number if it doesn't have
+// a name here. Returns an empty string if path can't be checked.
+func filesystemType(path string) string {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return ""
+	}
+	magic := uint32(fs.Type)
+	if name, ok := networkFilesystems[magic]; ok {
+		return name
+	}
+	if name, ok := filesystemNames[magic]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%x", magic)
+}
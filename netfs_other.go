@@ -0,0 +1,14 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package autorsync
+
+// Network filesystems aren't detected on this platform, so every source is watched.
+func networkFilesystem(path string) string {
+	return ""
+}
+
+// Every path counts as being on the same filesystem, so one watcher is used for all of them.
+func filesystemType(path string) string {
+	return ""
+}
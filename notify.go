@@ -0,0 +1,40 @@
+package autorsync
+
+import (
+	"fmt"
+	"os/exec"
+	"sync"
+
+	"github.com/dcrodman/autorsync/internal/logging"
+)
+
+var notifyUnavailable sync.Once
+
+// Show a desktop notification with the result of syncing mapping. Does nothing if the
+// platform's notification tool isn't available.
+func notifySyncResult(mapping *Mapping, err error) {
+	title := "autorsync: sync complete"
+	message := mapping.label()
+	if err != nil {
+		title = "autorsync: sync failed"
+		message = fmt.Sprintf("%s\n%v", message, err)
+	}
+
+	cmd := notificationCommand(title, message)
+	if cmd == nil {
+		return
+	}
+	if _, err := exec.LookPath(cmd.Path); err != nil {
+		notifyUnavailable.Do(func() {
+			logging.Errorf("desktop notifications unavailable: %v", err)
+		})
+		return
+	}
+
+	// Don't hold up the sync loop waiting on the notification.
+	go func() {
+		if err := cmd.Run(); err != nil {
+			logging.Errorf("failed to show notification: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,12 @@
+package autorsync
+
+import (
+	"os/exec"
+	"strings"
+)
+
+func notificationCommand(title, message string) *exec.Cmd {
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	script := `display notification "` + quote.Replace(message) + `" with title "` + quote.Replace(title) + `"`
+	return exec.Command("osascript", "-e", script)
+}
@@ -0,0 +1,7 @@
+package autorsync
+
+import "os/exec"
+
+func notificationCommand(title, message string) *exec.Cmd {
+	return exec.Command("notify-send", title, message)
+}
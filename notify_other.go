@@ -0,0 +1,11 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package autorsync
+
+import "os/exec"
+
+// Desktop notifications aren't supported on this platform.
+func notificationCommand(title, message string) *exec.Cmd {
+	return nil
+}
@@ -0,0 +1,174 @@
+package autorsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// What polling compares between passes to tell whether a file has changed.
+type fileState struct {
+	modTime time.Time
+	size    int64
+	mode    os.FileMode
+}
+
+// Watch mapping's source for changes, or poll it instead if use_polling is set or it's on a
+// network filesystem, where file events only arrive for changes made on this machine. A
+// source that doesn't exist is skipped with a warning.
+func (s *Syncer) watchOrPoll(watcher *watcherSet, mapping *Mapping) error {
+	_, err := os.Stat(mapping.Source)
+	mapping.missing = os.IsNotExist(err)
+	if mapping.missing {
+		if s.config.Settings.WatchForCreation {
+			mappingLog(mapping).Warnf("%s doesn't exist, waiting for it to be created", mapping.Source)
+		} else {
+			mappingLog(mapping).Warnf("%s doesn't exist, not syncing %s", mapping.Source, mapping.label())
+		}
+		return nil
+	}
+	mapping.sourceGone = false
+
+	if s.config.Settings.UsePolling {
+		mappingLog(mapping).Infof("checking %s for changes every %s", mapping.Source, s.config.Settings.pollInterval)
+		mapping.polling = true
+		return nil
+	}
+	if fsType := networkFilesystem(mapping.Source); fsType != "" {
+		mappingLog(mapping).Infof("%s is on %s, checking it for changes every %s instead of watching it",
+			mapping.Source, fsType, s.config.Settings.pollInterval)
+		mapping.polling = true
+		return nil
+	}
+	return watchFilesInDirectory(watcher, s.watched, mapping, mapping.Source)
+}
+
+// Every poll_interval, compare the files in each polled mapping's source against what they
+// looked like last time and flag the mapping as dirty if anything changed, and check whether
+// deleted sources (or with watch_for_creation, any missing ones) have appeared. Runs until
+// shutdown is closed or ctx is cancelled.
+func (s *Syncer) pollForChanges(ctx context.Context) {
+	interval := s.config.Settings.pollInterval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.shutdown:
+			return
+		case <-ctx.Done():
+			return
+		}
+
+		s.needsRsyncMutex.Lock()
+		// Pick up interval changes from a config reload.
+		if newInterval := s.config.Settings.pollInterval; newInterval != interval {
+			interval = newInterval
+			ticker.Reset(interval)
+		}
+		var polled, missing []*Mapping
+		for _, mapping := range s.config.Mappings {
+			if !mapping.isEnabled() {
+				continue
+			}
+			if mapping.missing && (mapping.sourceGone || s.config.Settings.WatchForCreation) {
+				missing = append(missing, mapping)
+			} else if mapping.polling {
+				polled = append(polled, mapping)
+			}
+		}
+		s.needsRsyncMutex.Unlock()
+
+		for _, mapping := range polled {
+			s.pollMapping(mapping)
+		}
+		for _, mapping := range missing {
+			if _, err := os.Stat(mapping.Source); err == nil {
+				// The event loop owns the watches, so it starts watching the source.
+				if err := s.changeMappings(mappingChange{created: mapping}); err != nil && err != errNotRunning {
+					mappingLog(mapping).Errorf("%v", err)
+				}
+			}
+		}
+	}
+}
+
+// Take a new snapshot of mapping's source and mark it dirty if it's different from the last
+// one. Only called from pollForChanges, which owns mapping.snapshot.
+func (s *Syncer) pollMapping(mapping *Mapping) {
+	snapshot := snapshotFiles(mapping)
+	previous := mapping.snapshot
+	mapping.snapshot = snapshot
+	if previous == nil {
+		return
+	}
+
+	changed := changedPath(previous, snapshot)
+	if changed == "" {
+		return
+	}
+	mappingLog(mapping).WithEventPath(changed).Eventf("detected change to %s", changed)
+
+	s.needsRsyncMutex.Lock()
+	mapping.stats.eventCount++
+	mapping.lastEventPath = changed
+	s.lastEventAt = time.Now()
+	s.setNeedsRsync(mapping)
+	s.needsRsyncMutex.Unlock()
+}
+
+// Record the state of every file and directory under mapping.Source that isn't excluded.
+func snapshotFiles(mapping *Mapping) map[string]fileState {
+	snapshot := make(map[string]fileState)
+	filepath.Walk(mapping.Source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Files can disappear mid-walk; they'll show up as removed.
+			return nil
+		}
+		path = filepath.Clean(path)
+		if isExcluded(mapping, path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		snapshot[path] = fileState{modTime: info.ModTime(), size: info.Size(), mode: info.Mode()}
+		if info.IsDir() && mapping.MaxDepth > 0 && pathDepth(mapping, path) >= mapping.MaxDepth {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return snapshot
+}
+
+// Return one of the paths that was added, removed or modified between two snapshots, or an
+// empty string if they're the same.
+func changedPath(before, after map[string]fileState) string {
+	var changed []string
+	for path, state := range after {
+		if previous, ok := before[path]; !ok || previous != state {
+			changed = append(changed, path)
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	if len(changed) == 0 {
+		return ""
+	}
+	// Report the same path every time for the same set of changes, preferring files over the
+	// directories whose modification times change along with them.
+	sort.Strings(changed)
+	for _, path := range changed {
+		if state, ok := after[path]; ok && !state.mode.IsDir() {
+			return path
+		}
+	}
+	return changed[0]
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package autorsync
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Run cmd in its own process group so that it and anything it starts (like ssh) can be
+// killed together, and so that a Ctrl-C in the terminal doesn't interrupt it mid-transfer.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
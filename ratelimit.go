@@ -0,0 +1,30 @@
+package autorsync
+
+import "time"
+
+// A token bucket allowing up to perMinute events per minute, refilled continuously. The bucket
+// starts full, so a burst of up to perMinute events is allowed straight away.
+type rateLimiter struct {
+	perMinute int
+	tokens    float64
+	last      time.Time
+}
+
+func newRateLimiter(perMinute int) *rateLimiter {
+	return &rateLimiter{perMinute: perMinute, tokens: float64(perMinute), last: time.Now()}
+}
+
+// Take a token if one is available at now.
+func (l *rateLimiter) allow(now time.Time) bool {
+	l.tokens += now.Sub(l.last).Minutes() * float64(l.perMinute)
+	if l.tokens > float64(l.perMinute) {
+		l.tokens = float64(l.perMinute)
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
@@ -0,0 +1,77 @@
+package autorsync
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Convert a regex exclusion into the closest rsync --exclude pattern for mapping. Only simple
+// regexes made up of literals, ".", ".*", character classes and the ^ and $ anchors can be
+// converted; ok is false for anything else. The regex is matched against paths relative to
+// the source, so a ^ anchor becomes an rsync anchor at the source directory.
+func regexToRsyncPattern(mapping *Mapping, re string) (pattern string, ok bool) {
+	anchoredStart := strings.HasPrefix(re, "^")
+	re = strings.TrimPrefix(re, "^")
+	if strings.HasPrefix(re, ".*") {
+		// Matches from anywhere, same as not being anchored.
+		anchoredStart = false
+		re = strings.TrimPrefix(re, ".*")
+	}
+
+	anchoredEnd := strings.HasSuffix(re, "$") && !strings.HasSuffix(re, `\$`)
+	if anchoredEnd {
+		re = strings.TrimSuffix(re, "$")
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(re); i++ {
+		switch c := re[i]; c {
+		case '.':
+			if i+1 < len(re) && re[i+1] == '*' {
+				b.WriteString("**")
+				i++
+			} else {
+				b.WriteByte('?')
+			}
+		case '\\':
+			if i+1 == len(re) || !strings.ContainsRune(`.\/-_*?[](){}|^$+`, rune(re[i+1])) {
+				// Classes like \d and \w have no rsync equivalent.
+				return "", false
+			}
+			i++
+			if strings.ContainsRune(`*?[\`, rune(re[i])) {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(re[i])
+		case '[':
+			end := strings.IndexByte(re[i+1:], ']')
+			if end < 0 {
+				return "", false
+			}
+			b.WriteString(re[i : i+end+2])
+			i += end + 1
+		case '*', '+', '?', '(', ')', '{', '}', '|', '^', '$':
+			return "", false
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	pattern = b.String()
+	if pattern == "" {
+		return "", false
+	}
+	if !anchoredEnd && !strings.HasSuffix(pattern, "/") {
+		pattern += "*"
+	}
+
+	if !anchoredStart {
+		return "*" + strings.TrimPrefix(pattern, "*"), true
+	}
+	// rsync anchors patterns to the transfer root, which for a source without a trailing slash
+	// is the directory containing it.
+	if strings.HasSuffix(mapping.Source, "/") {
+		return "/" + pattern, true
+	}
+	return "/" + filepath.Base(mapping.Source) + "/" + pattern, true
+}
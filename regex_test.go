@@ -0,0 +1,42 @@
+package autorsync
+
+import "testing"
+
+func TestRegexToRsyncPattern(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		re     string
+		want   string
+		wantOK bool
+	}{
+		{name: "unanchored literal", source: "/home/proj", re: "tmp", want: "*tmp*", wantOK: true},
+		{name: "end anchor", source: "/home/proj", re: `\.log$`, want: "*.log", wantOK: true},
+		{name: "leading dot star", source: "/home/proj", re: `.*\.bak$`, want: "*.bak", wantOK: true},
+		{name: "start anchor", source: "/home/proj", re: "^build/", want: "/proj/build/", wantOK: true},
+		{name: "start anchor with trailing slash source", source: "/home/proj/", re: "^build/", want: "/build/", wantOK: true},
+		{name: "start anchored dot star is unanchored", source: "/home/proj", re: `^.*\.o$`, want: "*.o", wantOK: true},
+		{name: "dot", source: "/home/proj", re: "^a.c$", want: "/proj/a?c", wantOK: true},
+		{name: "dot star", source: "/home/proj", re: "^cache/.*", want: "/proj/cache/***", wantOK: true},
+		{name: "character class", source: "/home/proj", re: `^[ab]\.txt$`, want: "/proj/[ab].txt", wantOK: true},
+		{name: "escaped glob character", source: "/home/proj", re: `file\*$`, want: `*file\*`, wantOK: true},
+		{name: "escaped dollar", source: "/home/proj", re: `cost\$`, want: "*cost$*", wantOK: true},
+		{name: "repetition", source: "/home/proj", re: "[0-9]+", wantOK: false},
+		{name: "optional", source: "/home/proj", re: "colou?r", wantOK: false},
+		{name: "alternation", source: "/home/proj", re: "a|b", wantOK: false},
+		{name: "group", source: "/home/proj", re: "(a)", wantOK: false},
+		{name: "class escape", source: "/home/proj", re: `\d`, wantOK: false},
+		{name: "trailing backslash", source: "/home/proj", re: `a\`, wantOK: false},
+		{name: "unclosed class", source: "/home/proj", re: "[abc", wantOK: false},
+		{name: "empty", source: "/home/proj", re: "^$", wantOK: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := regexToRsyncPattern(&Mapping{Source: test.source}, test.re)
+			if ok != test.wantOK || got != test.want {
+				t.Errorf("regexToRsyncPattern(%q) = %q, %v, want %q, %v", test.re, got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package autorsync
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long to wait for ssh to connect when checking a remote target.
+const remoteCheckTimeout = 5 * time.Second
+
+// Return the host part of an ssh target like user@host:/path, or an empty string if target
+// is a local path. Like rsync, a target is remote when a colon comes before any slash.
+// rsync daemon targets aren't reached over ssh, so they count as local here. IPv6 addresses
+// are returned without their brackets, which is how ssh takes them.
+func remoteHost(target string) string {
+	colon := hostColon(target)
+	if colon <= 0 || strings.Contains(target[:colon], "/") || strings.HasPrefix(target[colon:], "::") {
+		return ""
+	}
+	return strings.NewReplacer("[", "", "]", "").Replace(target[:colon])
+}
+
+// Return the index of the colon that ends the host part of an rsync target, or -1 if there
+// isn't one. The colons inside the brackets around an IPv6 address, as in
+// user@[2001:db8::1]:/path, don't count.
+func hostColon(target string) int {
+	start := 0
+	if open := strings.Index(target, "["); open >= 0 && !strings.ContainsAny(target[:open], ":/") {
+		if end := strings.Index(target[open:], "]"); end > 0 {
+			start = open + end + 1
+		}
+	}
+	colon := strings.Index(target[start:], ":")
+	if colon < 0 {
+		return -1
+	}
+	return start + colon
+}
+
+// Try connecting to the mapping's remote host with ssh.
+func checkRemote(ctx context.Context, mapping *Mapping, host string) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*remoteCheckTimeout)
+	defer cancel()
+
+	args := []string{"-o", fmt.Sprintf("ConnectTimeout=%d", int(remoteCheckTimeout.Seconds())), "-o", "BatchMode=yes"}
+	args = append(args, sshOptions(mapping)...)
+	args = append(args, host, "exit")
+
+	output, err := exec.CommandContext(ctx, "ssh", args...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// Check every enabled mapping with a remote target at once, warning about the ones that can't
+// be reached and flagging them to be synced on the first tick so the connection is retried
+// straight away.
+func (s *Syncer) checkRemoteTargets(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, mapping := range s.config.Mappings {
+		host := remoteHost(expandTargetTemplate(mapping, time.Now()))
+		if mapping.targetKind() != targetSSH || !mapping.isEnabled() {
+			continue
+		}
+
+		wg.Add(1)
+		m := mapping
+		go func() {
+			defer wg.Done()
+			if err := checkRemote(ctx, m, host); err != nil {
+				mappingLog(m).Warnf("unable to reach %s for %s: %v", host, m.label(), err)
+				s.markNeedsRsync(m)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,37 @@
+package autorsync
+
+import "testing"
+
+func TestRemoteHost(t *testing.T) {
+	tests := []struct {
+		target    string
+		wantColon int
+		wantHost  string
+	}{
+		{target: "/backup", wantColon: -1, wantHost: ""},
+		{target: "backup", wantColon: -1, wantHost: ""},
+		{target: "user@host:/backup", wantColon: 9, wantHost: "user@host"},
+		{target: "host:backup", wantColon: 4, wantHost: "host"},
+		{target: "host:", wantColon: 4, wantHost: "host"},
+		// A slash before the colon makes it a local path, as in rsync.
+		{target: "./a:b", wantColon: 3, wantHost: ""},
+		{target: "/tmp/[x]:y", wantColon: 8, wantHost: ""},
+		{target: ":backup", wantColon: 0, wantHost: ""},
+		// Daemon targets aren't reached over ssh.
+		{target: "host::module/path", wantColon: 4, wantHost: ""},
+		{target: "[::1]::module", wantColon: 5, wantHost: ""},
+		// The colons in an IPv6 address don't end the host.
+		{target: "user@[2001:db8::1]:/backup", wantColon: 18, wantHost: "user@2001:db8::1"},
+		{target: "[::1]:/backup", wantColon: 5, wantHost: "::1"},
+		{target: "[2001:db8::1]", wantColon: -1, wantHost: ""},
+	}
+
+	for _, test := range tests {
+		if got := hostColon(test.target); got != test.wantColon {
+			t.Errorf("hostColon(%q) = %d, want %d", test.target, got, test.wantColon)
+		}
+		if got := remoteHost(test.target); got != test.wantHost {
+			t.Errorf("remoteHost(%q) = %q, want %q", test.target, got, test.wantHost)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package autorsync
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Update mapping's retry state after a sync. Failures are retried while the mapping has
+// attempts left, each one waiting min(2^n * retry_base_delay, retry_max_delay). Without a
+// retry policy only timeouts and checksum mismatches are retried, on the next tick. After
+// circuit_breaker_threshold failures in a row the mapping isn't tried again until
+// circuit_breaker_reset_after has passed.
+func (s *Syncer) recordSyncResult(mapping *Mapping, err error) {
+	s.needsRsyncMutex.Lock()
+	defer s.needsRsyncMutex.Unlock()
+
+	mapping.oversized = false
+	if err == nil {
+		mapping.failures = 0
+		mapping.retryAt = time.Time{}
+		mapping.consecutiveFailures = 0
+		return
+	}
+
+	// Oversized syncs aren't failures, they just wait for the changes to be dealt with, and
+	// locked mappings wait for the other process to finish.
+	if errors.Is(err, errSyncTooLarge) || errors.Is(err, errMappingLocked) {
+		s.setNeedsRsync(mapping)
+		mapping.oversized = errors.Is(err, errSyncTooLarge)
+		return
+	}
+
+	mapping.consecutiveFailures++
+	if threshold := s.config.Settings.CircuitBreakerThreshold; threshold > 0 && mapping.consecutiveFailures >= threshold {
+		// Once the circuit closes again, a single failure is enough to reopen it.
+		resetAfter := s.config.Settings.circuitReset
+		mapping.circuitOpenUntil = time.Now().Add(resetAfter)
+		mappingLog(mapping).Errorf("%s failed %d times in a row, not trying again for %s",
+			mapping.label(), mapping.consecutiveFailures, resetAfter)
+		mapping.failures = 0
+		mapping.retryAt = time.Time{}
+		s.setNeedsRsync(mapping)
+		return
+	}
+
+	mapping.failures++
+	maxAttempts := s.config.Settings.RetryMaxAttempts
+
+	if maxAttempts == 0 {
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errChecksumMismatch) {
+			s.setNeedsRsync(mapping)
+		}
+		return
+	}
+
+	if mapping.failures > maxAttempts {
+		mappingLog(mapping).Errorf("giving up on %s after %d attempts", mapping.label(), mapping.failures)
+		mapping.failures = 0
+		mapping.retryAt = time.Time{}
+		return
+	}
+
+	delay := backoffDelay(mapping.failures, s.config.Settings.retryBaseDelay, s.config.Settings.retryMaxDelay)
+	mapping.retryAt = time.Now().Add(delay)
+	mappingLog(mapping).Infof("retrying %s in %s (retry %d of %d)", mapping.label(), delay, mapping.failures, maxAttempts)
+	s.setNeedsRsync(mapping)
+}
+
+// The delay before retry n (starting at 1) doubles each time, up to maxDelay.
+func backoffDelay(n int, baseDelay, maxDelay time.Duration) time.Duration {
+	delay := baseDelay
+	for i := 1; i < n && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
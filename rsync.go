@@ -0,0 +1,277 @@
+package autorsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Returned instead of syncing when the pending changes are over the mapping's
+// max_sync_files or max_sync_bytes.
+var errSyncTooLarge = errors.New("pending changes exceed the sync size limit")
+
+// Build and run the underlying rsync command to update mapping.Target with the
+// contents of mapping.Source. Returns rsync's output on success and how long it ran for,
+// which is zero if it didn't run.
+func (s *Syncer) runRsync(ctx context.Context, mapping *Mapping) ([]byte, time.Duration, error) {
+	mapping.syncTarget = expandTargetTemplate(mapping, time.Now())
+
+	// Measured first so that pre_sync doesn't run for a sync that's then skipped. Anything
+	// pre_sync writes isn't counted.
+	if (mapping.MaxSyncFiles > 0 || mapping.MaxSyncBytes > 0) && !s.options.DryRun {
+		if err := s.checkSyncSize(ctx, mapping); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	if mapping.PreSync != "" {
+		if err := s.runHook(ctx, mapping, "pre_sync", mapping.PreSync); err != nil {
+			mappingLog(mapping).Errorf("%v", err)
+			return nil, 0, err
+		}
+	}
+
+	start := time.Now()
+	output, err := s.execRsync(ctx, mapping, s.rsyncArgs(mapping))
+
+	var duration time.Duration
+	if !s.options.DryRun {
+		duration = time.Since(start)
+		if err == nil {
+			mappingLog(mapping).Outputf("%s", output)
+		}
+		mappingLog(mapping).Infof("rsync of %s took %s", mapping.label(), duration.Round(time.Millisecond))
+		s.logSyncEvent(mapping, start, duration, err)
+
+		if err == nil && mapping.VerifyChecksums {
+			if err = verifyChecksums(mapping); err != nil {
+				mappingLog(mapping).Errorf("%v", err)
+			}
+		}
+	}
+
+	// post_sync only runs after a successful sync, and failing doesn't fail the sync.
+	if err == nil && mapping.PostSync != "" {
+		if err := s.runHook(ctx, mapping, "post_sync", mapping.PostSync); err != nil {
+			mappingLog(mapping).Errorf("%v", err)
+		}
+	}
+	// The same goes for the webhook, which isn't sent for dry runs.
+	if err == nil && mapping.WebhookURL != "" && !s.options.DryRun {
+		if err := sendWebhook(ctx, mapping, duration); err != nil {
+			mappingLog(mapping).Errorf("%v", err)
+		}
+	}
+	return output, duration, err
+}
+
+// Measure the pending transfer with a dry run and return errSyncTooLarge if it's over either
+// of the mapping's limits.
+func (s *Syncer) checkSyncSize(ctx context.Context, mapping *Mapping) error {
+	output, err := s.execRsync(ctx, mapping, s.rsyncArgs(mapping, "--dry-run", "--stats"))
+	if err != nil {
+		return err
+	}
+
+	pending := parseTransferStats(output)
+	if mapping.MaxSyncFiles > 0 && pending.FilesTransferred > mapping.MaxSyncFiles {
+		mappingLog(mapping).Warnf("not syncing %s: %d files changed, more than max_sync_files (%d)",
+			mapping.label(), pending.FilesTransferred, mapping.MaxSyncFiles)
+		return errSyncTooLarge
+	}
+	if mapping.MaxSyncBytes > 0 && pending.BytesTransferred > mapping.MaxSyncBytes {
+		mappingLog(mapping).Warnf("not syncing %s: %d bytes changed, more than max_sync_bytes (%d)",
+			mapping.label(), pending.BytesTransferred, mapping.MaxSyncBytes)
+		return errSyncTooLarge
+	}
+	return nil
+}
+
+// Build the arguments to rsync mapping, with extraArgs added before the source and target.
+func (s *Syncer) rsyncArgs(mapping *Mapping, extraArgs ...string) []string {
+	args := make([]string, 0)
+	args = append(args, s.config.Settings.BaseArgs...)
+	if s.options.DryRun {
+		args = append(args, "--dry-run")
+	}
+	target := mapping.syncTarget
+	switch mapping.targetKind() {
+	case targetSSH:
+		// The SSH options only mean anything when rsync connects to the target over ssh.
+		if ssh := sshCommand(mapping); ssh != "" {
+			args = append(args, "-e", ssh)
+		}
+	case targetDaemon:
+		if mapping.RsyncModule != "" {
+			target = daemonTarget(target, mapping.RsyncModule)
+		}
+		if mapping.RsyncPasswordFile != "" {
+			args = append(args, "--password-file="+mapping.RsyncPasswordFile)
+		}
+	}
+
+	rsyncArgs := s.config.Settings.RsyncArgs
+	if mapping.RsyncArgs != nil {
+		if s.config.Settings.PerMappingArgsMode == "replace" {
+			rsyncArgs = mapping.RsyncArgs
+		} else {
+			rsyncArgs = append(append([]string{}, rsyncArgs...), mapping.RsyncArgs...)
+		}
+	}
+
+	for _, arg := range rsyncArgs {
+		args = append(args, os.ExpandEnv(arg))
+	}
+
+	for _, exclusion := range mapping.Exclusions {
+		args = append(args, "--exclude="+exclusion)
+	}
+	for _, pattern := range mapping.rsyncExclusionPatterns {
+		args = append(args, "--exclude="+pattern)
+	}
+	for _, excludeFrom := range mapping.ExcludeFromFiles {
+		args = append(args, "--exclude-from="+excludeFrom)
+	}
+	if mapping.DeleteExtraneous {
+		args = append(args, "--delete")
+	}
+	if mapping.Checksum {
+		args = append(args, "--checksum")
+	}
+	if mapping.Partial {
+		args = append(args, "--partial")
+	}
+	if mapping.PartialDir != "" {
+		args = append(args, "--partial-dir="+mapping.PartialDir)
+	}
+	bwlimit := s.config.Settings.BwlimitKbps
+	if mapping.BwlimitKbps != 0 {
+		bwlimit = mapping.BwlimitKbps
+	}
+	if bwlimit != 0 {
+		args = append(args, fmt.Sprintf("--bwlimit=%d", bwlimit))
+	}
+	if s.config.Settings.CaptureStats {
+		args = append(args, "--stats")
+	}
+	if mapping.gitignore {
+		// Let rsync apply each directory's .gitignore itself.
+		args = append(args, "--filter=:- .gitignore")
+	}
+	if !mapping.hiddenFiles && !isRegularFile(mapping.Source) {
+		// Without a trailing slash rsync matches the source directory's own name against the
+		// filters too, so a hidden source needs to be let through.
+		if name := filepath.Base(mapping.Source); !strings.HasSuffix(mapping.Source, "/") && strings.HasPrefix(name, ".") {
+			args = append(args, "--include=/"+name)
+		}
+		args = append(args, "--exclude=.*")
+	}
+
+	// These come after the other filters so that excluded files stay excluded whatever their
+	// extension.
+	for _, ext := range mapping.ExcludeExtensions {
+		args = append(args, "--exclude=*"+ext)
+	}
+	if len(mapping.IncludeExtensions) > 0 {
+		// Descend into every directory, but only keep the ones that end up with matching files.
+		args = append(args, "--include=*/")
+		for _, ext := range mapping.IncludeExtensions {
+			args = append(args, "--include=*"+ext)
+		}
+		args = append(args, "--exclude=*", "--prune-empty-dirs")
+	}
+
+	args = append(args, extraArgs...)
+	return append(args, mapping.Source, target)
+}
+
+// Run rsync with args, killing it if it takes longer than the rsync timeout or ctx is
+// cancelled. Returns rsync's output on success.
+func (s *Syncer) execRsync(ctx context.Context, mapping *Mapping, args []string) ([]byte, error) {
+	if s.options.RsyncTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.options.RsyncTimeout)
+		defer cancel()
+	}
+	rsyncPath := s.options.RsyncPath
+	if mapping.RsyncBinary != "" {
+		rsyncPath = mapping.RsyncBinary
+	}
+	mappingLog(mapping).Infof("%s", strings.Join(append([]string{rsyncPath}, args...), " "))
+	if s.options.DryRun {
+		return nil, nil
+	}
+
+	output, err := s.executor(ctx, rsyncPath, args...)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			mappingLog(mapping).Errorf("rsync of %s killed after %s", mapping.label(), s.options.RsyncTimeout)
+			return nil, fmt.Errorf("rsync timed out: %w", ctx.Err())
+		} else if ctx.Err() == context.Canceled {
+			mappingLog(mapping).Errorf("rsync of %s cancelled", mapping.label())
+			return nil, fmt.Errorf("rsync cancelled: %w", ctx.Err())
+		} else if exitErr, ok := err.(*exec.ExitError); ok {
+			mappingLog(mapping).Errorf("rsync of %s failed: %s", mapping.label(), exitErr.Stderr)
+		} else {
+			mappingLog(mapping).Errorf("rsync of %s failed: %v", mapping.label(), err)
+		}
+		return nil, err
+	}
+
+	return output, nil
+}
+
+// Build the remote shell command for rsync's -e flag from the mapping's SSH options, or
+// return an empty string if none are set. rsync splits the command on whitespace itself,
+// so options with spaces or quotes in them (like a key under "My Documents") are quoted.
+func sshCommand(mapping *Mapping) string {
+	options := sshOptions(mapping)
+	if len(options) == 0 {
+		return ""
+	}
+	words := []string{"ssh"}
+	for _, option := range options {
+		words = append(words, quoteRemoteShellArg(option))
+	}
+	return strings.Join(words, " ")
+}
+
+// Quote arg for the -e command if it needs it. rsync joins adjacent quoted pieces the way a
+// shell does, so a single quote is written as '"'"'.
+func quoteRemoteShellArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " '\"") {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'"'"'`) + "'"
+}
+
+// The ssh flags for the mapping's SSH options.
+func sshOptions(mapping *Mapping) []string {
+	var options []string
+	if mapping.SSHKey != "" {
+		options = append(options, "-i", mapping.SSHKey)
+	}
+	if mapping.SSHUser != "" {
+		options = append(options, "-l", mapping.SSHUser)
+	}
+	if mapping.SSHPort != 0 {
+		options = append(options, "-p", strconv.Itoa(mapping.SSHPort))
+	}
+	if mapping.SSHKnownHostsFile != "" {
+		options = append(options, "-o", "UserKnownHostsFile="+mapping.SSHKnownHostsFile)
+	}
+	// Without a known hosts file, leave host key checking to ssh's own config unless it's
+	// been set explicitly.
+	if strict := mapping.SSHStrictHostChecking; strict != nil && !*strict {
+		options = append(options, "-o", "StrictHostKeyChecking=no")
+	} else if strict != nil || mapping.SSHKnownHostsFile != "" {
+		options = append(options, "-o", "StrictHostKeyChecking=yes")
+	}
+	return options
+}
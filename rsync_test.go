@@ -0,0 +1,354 @@
+package autorsync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Records each command the Syncer runs, and answers it with respond if that's set.
+type fakeExecutor struct {
+	mu      sync.Mutex
+	calls   [][]string
+	respond func(ctx context.Context, args []string) ([]byte, error)
+}
+
+func (f *fakeExecutor) run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, append([]string{name}, args...))
+	f.mu.Unlock()
+	if f.respond != nil {
+		return f.respond(ctx, args)
+	}
+	return nil, nil
+}
+
+// Prepare mapping like one read from a config file with settings, and return a Syncer that
+// runs rsync through run.
+func newTestSyncer(t *testing.T, settings *Settings, options Options, mapping *Mapping, run executor) *Syncer {
+	t.Helper()
+	if err := prepareMapping(settings, mapping, nil); err != nil {
+		t.Fatalf("prepareMapping: %v", err)
+	}
+	s := NewSyncer(&Config{Settings: settings, Mappings: []*Mapping{mapping}}, options)
+	if run != nil {
+		s.executor = run
+	}
+	return s
+}
+
+func TestRsyncArgs(t *testing.T) {
+	source := t.TempDir()
+	hiddenSource := filepath.Join(t.TempDir(), ".config")
+	if err := os.Mkdir(hiddenSource, 0755); err != nil {
+		t.Fatal(err)
+	}
+	no := false
+
+	tests := []struct {
+		name     string
+		settings Settings
+		mapping  Mapping
+		dryRun   bool
+		extra    []string
+		want     []string
+	}{
+		{
+			name:     "local target",
+			settings: Settings{BaseArgs: []string{"-a"}},
+			mapping:  Mapping{Source: source, Target: "/backup", Exclusions: []string{".git"}},
+			want:     []string{"-a", "--exclude=.git", source, "/backup"},
+		},
+		{
+			name:     "dry run with extra args",
+			settings: Settings{BaseArgs: []string{"-a"}},
+			mapping:  Mapping{Source: source, Target: "/backup"},
+			dryRun:   true,
+			extra:    []string{"--stats"},
+			want:     []string{"-a", "--dry-run", "--stats", source, "/backup"},
+		},
+		{
+			name:     "ssh target",
+			settings: Settings{BaseArgs: []string{"-a"}},
+			mapping:  Mapping{Source: source, Target: "host:/backup", SSHUser: "deploy", SSHPort: 2222},
+			want:     []string{"-a", "-e", "ssh -l deploy -p 2222", source, "host:/backup"},
+		},
+		{
+			name:     "ssh key with a space",
+			settings: Settings{BaseArgs: []string{"-a"}},
+			mapping:  Mapping{Source: source, Target: "host:/backup", SSHKey: "/keys/my key"},
+			want:     []string{"-a", "-e", "ssh -i '/keys/my key'", source, "host:/backup"},
+		},
+		{
+			name:     "ssh options ignored for a local target",
+			settings: Settings{BaseArgs: []string{"-a"}},
+			mapping:  Mapping{Source: source, Target: "/backup", SSHPort: 2222},
+			want:     []string{"-a", source, "/backup"},
+		},
+		{
+			name:     "daemon module",
+			settings: Settings{BaseArgs: []string{"-a"}},
+			mapping:  Mapping{Source: source, Target: "nas:photos", RsyncModule: "backup", RsyncPasswordFile: "/etc/rsync.secret"},
+			want:     []string{"-a", "--password-file=/etc/rsync.secret", source, "rsync://nas/backup/photos"},
+		},
+		{
+			name:     "daemon url",
+			settings: Settings{BaseArgs: []string{"-a"}},
+			mapping:  Mapping{Source: source, Target: "rsync://nas/backup", SSHPort: 2222},
+			want:     []string{"-a", source, "rsync://nas/backup"},
+		},
+		{
+			name:     "per-mapping args appended",
+			settings: Settings{BaseArgs: []string{"-a"}, RsyncArgs: []string{"--no-perms"}, PerMappingArgsMode: "append"},
+			mapping:  Mapping{Source: source, Target: "/backup", RsyncArgs: []string{"--inplace"}},
+			want:     []string{"-a", "--no-perms", "--inplace", source, "/backup"},
+		},
+		{
+			name:     "per-mapping args replace",
+			settings: Settings{BaseArgs: []string{"-a"}, RsyncArgs: []string{"--no-perms"}, PerMappingArgsMode: "replace"},
+			mapping:  Mapping{Source: source, Target: "/backup", RsyncArgs: []string{"--inplace"}},
+			want:     []string{"-a", "--inplace", source, "/backup"},
+		},
+		{
+			name:     "settings args without per-mapping args",
+			settings: Settings{BaseArgs: []string{"-a"}, RsyncArgs: []string{"--no-perms"}, PerMappingArgsMode: "replace"},
+			mapping:  Mapping{Source: source, Target: "/backup"},
+			want:     []string{"-a", "--no-perms", source, "/backup"},
+		},
+		{
+			name:     "extensions",
+			settings: Settings{BaseArgs: []string{"-a"}},
+			mapping: Mapping{Source: source, Target: "/backup",
+				IncludeExtensions: []string{"go", ".md"}, ExcludeExtensions: []string{"log"}},
+			want: []string{"-a", "--exclude=*.log", "--include=*/", "--include=*.go", "--include=*.md",
+				"--exclude=*", "--prune-empty-dirs", source, "/backup"},
+		},
+		{
+			name:     "hidden files excluded",
+			settings: Settings{BaseArgs: []string{"-a"}, WatchHiddenFiles: &no},
+			mapping:  Mapping{Source: source, Target: "/backup"},
+			want:     []string{"-a", "--exclude=.*", source, "/backup"},
+		},
+		{
+			name:     "hidden files excluded from a hidden source",
+			settings: Settings{BaseArgs: []string{"-a"}, WatchHiddenFiles: &no},
+			mapping:  Mapping{Source: hiddenSource, Target: "/backup"},
+			want:     []string{"-a", "--include=/.config", "--exclude=.*", hiddenSource, "/backup"},
+		},
+		{
+			name:     "hidden files excluded from inside a hidden source",
+			settings: Settings{BaseArgs: []string{"-a"}, WatchHiddenFiles: &no},
+			mapping:  Mapping{Source: hiddenSource + "/", Target: "/backup"},
+			want:     []string{"-a", "--exclude=.*", hiddenSource + "/", "/backup"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mapping := test.mapping
+			s := newTestSyncer(t, &test.settings, Options{DryRun: test.dryRun}, &mapping, nil)
+			mapping.syncTarget = mapping.Target
+
+			got := s.rsyncArgs(&mapping, test.extra...)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("rsyncArgs() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func requireShell(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("hooks need sh")
+	}
+}
+
+func TestRunRsyncHooks(t *testing.T) {
+	requireShell(t)
+	order := filepath.Join(t.TempDir(), "order")
+	note := func(step string) {
+		f, err := os.OpenFile(order, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(step + "\n")
+		f.Close()
+	}
+
+	tests := []struct {
+		name     string
+		preSync  string
+		rsyncErr error
+		wantErr  bool
+		want     string
+	}{
+		{name: "both hooks run", preSync: "echo pre >> " + order, want: "pre\nrsync\npost\n"},
+		{name: "failed pre_sync skips rsync", preSync: "echo pre >> " + order + "; exit 1", wantErr: true, want: "pre\n"},
+		{name: "failed rsync skips post_sync", preSync: "echo pre >> " + order, rsyncErr: errors.New("rsync failed"),
+			wantErr: true, want: "pre\nrsync\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			os.Remove(order)
+			fake := &fakeExecutor{respond: func(ctx context.Context, args []string) ([]byte, error) {
+				note("rsync")
+				return nil, test.rsyncErr
+			}}
+			mapping := &Mapping{Source: t.TempDir(), Target: "/backup", PreSync: test.preSync, PostSync: "echo post >> " + order}
+			s := newTestSyncer(t, &Settings{}, Options{}, mapping, fake.run)
+
+			_, _, err := s.runRsync(context.Background(), mapping)
+			if (err != nil) != test.wantErr {
+				t.Errorf("runRsync() error = %v, want error: %v", err, test.wantErr)
+			}
+			got, _ := os.ReadFile(order)
+			if string(got) != test.want {
+				t.Errorf("ran %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRunRsyncFailedPostSync(t *testing.T) {
+	requireShell(t)
+	fake := &fakeExecutor{}
+	mapping := &Mapping{Source: t.TempDir(), Target: "/backup", PostSync: "exit 1"}
+	s := newTestSyncer(t, &Settings{}, Options{}, mapping, fake.run)
+
+	if _, _, err := s.runRsync(context.Background(), mapping); err != nil {
+		t.Errorf("runRsync() error = %v, want a failed post_sync to be ignored", err)
+	}
+}
+
+func TestRunRsyncDryRunSkipsHooks(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "ran")
+	fake := &fakeExecutor{}
+	mapping := &Mapping{Source: t.TempDir(), Target: "/backup", PreSync: "touch " + marker, PostSync: "touch " + marker}
+	s := newTestSyncer(t, &Settings{}, Options{DryRun: true}, mapping, fake.run)
+
+	if _, _, err := s.runRsync(context.Background(), mapping); err != nil {
+		t.Fatalf("runRsync() error = %v", err)
+	}
+	if len(fake.calls) != 0 {
+		t.Errorf("ran %q, want nothing run for a dry run", fake.calls)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("hooks ran for a dry run")
+	}
+}
+
+func TestRunRsyncMaxSyncFiles(t *testing.T) {
+	stats := []byte("Number of files transferred: 5\nTotal transferred file size: 2,048 bytes\n")
+
+	tests := []struct {
+		name         string
+		maxSyncFiles int64
+		maxSyncBytes int64
+		wantErr      error
+		wantCalls    int
+	}{
+		{name: "under the limit", maxSyncFiles: 5, wantCalls: 2},
+		{name: "over max_sync_files", maxSyncFiles: 4, wantErr: errSyncTooLarge, wantCalls: 1},
+		{name: "over max_sync_bytes", maxSyncBytes: 1024, wantErr: errSyncTooLarge, wantCalls: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &fakeExecutor{respond: func(ctx context.Context, args []string) ([]byte, error) {
+				for _, arg := range args {
+					if arg == "--dry-run" {
+						return stats, nil
+					}
+				}
+				return nil, nil
+			}}
+			mapping := &Mapping{Source: t.TempDir(), Target: "/backup", MaxSyncFiles: test.maxSyncFiles, MaxSyncBytes: test.maxSyncBytes}
+			s := newTestSyncer(t, &Settings{}, Options{}, mapping, fake.run)
+
+			_, _, err := s.runRsync(context.Background(), mapping)
+			if err != test.wantErr {
+				t.Errorf("runRsync() error = %v, want %v", err, test.wantErr)
+			}
+			if len(fake.calls) != test.wantCalls {
+				t.Fatalf("ran rsync %d times, want %d: %q", len(fake.calls), test.wantCalls, fake.calls)
+			}
+			measure := strings.Join(fake.calls[0], " ")
+			if !strings.Contains(measure, "--dry-run --stats") {
+				t.Errorf("measured the sync with %q, want --dry-run --stats", measure)
+			}
+		})
+	}
+}
+
+func TestRunRsyncTimeout(t *testing.T) {
+	fake := &fakeExecutor{respond: func(ctx context.Context, args []string) ([]byte, error) {
+		<-ctx.Done()
+		return nil, errors.New("signal: killed")
+	}}
+	mapping := &Mapping{Source: t.TempDir(), Target: "/backup"}
+	s := newTestSyncer(t, &Settings{}, Options{RsyncTimeout: 50 * time.Millisecond}, mapping, fake.run)
+
+	_, _, err := s.runRsync(context.Background(), mapping)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("runRsync() error = %v, want it to time out", err)
+	}
+}
+
+func TestRunRsyncCancel(t *testing.T) {
+	started := make(chan struct{})
+	fake := &fakeExecutor{respond: func(ctx context.Context, args []string) ([]byte, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, errors.New("signal: killed")
+	}}
+	mapping := &Mapping{Source: t.TempDir(), Target: "/backup"}
+	s := newTestSyncer(t, &Settings{}, Options{}, mapping, fake.run)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	_, _, err := s.runRsync(ctx, mapping)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("runRsync() error = %v, want it to be cancelled", err)
+	}
+}
+
+func TestRunRsyncBinary(t *testing.T) {
+	fake := &fakeExecutor{}
+	mapping := &Mapping{Source: t.TempDir(), Target: "/backup", RsyncBinary: "/opt/rsync/bin/rsync"}
+	s := newTestSyncer(t, &Settings{}, Options{RsyncPath: "rsync"}, mapping, fake.run)
+
+	if _, _, err := s.runRsync(context.Background(), mapping); err != nil {
+		t.Fatalf("runRsync() error = %v", err)
+	}
+	if len(fake.calls) != 1 || fake.calls[0][0] != "/opt/rsync/bin/rsync" {
+		t.Errorf("ran %q, want mapping's rsync_binary", fake.calls)
+	}
+}
+
+func TestRunRsyncOversizedSkipsPreSync(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "ran")
+	fake := &fakeExecutor{respond: func(ctx context.Context, args []string) ([]byte, error) {
+		return []byte("Number of files transferred: 5\n"), nil
+	}}
+	mapping := &Mapping{Source: t.TempDir(), Target: "/backup", MaxSyncFiles: 1, PreSync: "touch " + marker}
+	s := newTestSyncer(t, &Settings{}, Options{}, mapping, fake.run)
+
+	if _, _, err := s.runRsync(context.Background(), mapping); err != errSyncTooLarge {
+		t.Fatalf("runRsync() error = %v, want %v", err, errSyncTooLarge)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("pre_sync ran for a sync that was skipped")
+	}
+}
@@ -0,0 +1,39 @@
+package autorsync
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The oldest rsync that autorsync is known to work with.
+const minRsyncMajorVersion = 3
+
+// Matches the first line of rsync --version, like "rsync  version 3.2.7  protocol version 31".
+var rsyncVersionPattern = regexp.MustCompile(`^rsync\s+version\s+v?(\d+)\.(\d+)\S*`)
+
+// CheckRsyncVersion runs rsyncPath --version and returns the first line of its output, or an
+// error if it can't be run or is older than rsync 3.0.
+func CheckRsyncVersion(rsyncPath string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, rsyncPath, "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run %s --version: %w", rsyncPath, err)
+	}
+
+	line := strings.TrimSpace(strings.SplitN(string(output), "\n", 2)[0])
+	match := rsyncVersionPattern.FindStringSubmatch(line)
+	if match == nil {
+		return "", fmt.Errorf("%s doesn't look like rsync: %q", rsyncPath, line)
+	}
+	if major, _ := strconv.Atoi(match[1]); major < minRsyncMajorVersion {
+		return "", fmt.Errorf("%s is rsync %s.%s, but autorsync needs %d.0 or later", rsyncPath, match[1], match[2], minRsyncMajorVersion)
+	}
+	return line, nil
+}
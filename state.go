@@ -0,0 +1,70 @@
+package autorsync
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/dcrodman/autorsync/internal/logging"
+)
+
+// An entry in the state file for a mapping with changes that haven't been synced yet.
+type stateRecord struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// Flag the mappings listed in the state file as dirty so that changes which hadn't been synced
+// when the last run stopped aren't lost. Called by Start before anything else uses needsRsync.
+func (s *Syncer) restoreState() {
+	path := s.config.Settings.StateFile
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		logging.Errorf("failed to read state file: %v", err)
+		return
+	}
+
+	var records []stateRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		logging.Errorf("failed to parse state file %s: %v", path, err)
+		return
+	}
+
+	for _, record := range records {
+		for mapping := range s.needsRsync {
+			if mapping.Source == record.Source && mapping.Target == record.Target {
+				mappingLog(mapping).Infof("%s has unsynced changes from the last run", mapping.label())
+				s.needsRsync[mapping] = true
+			}
+		}
+	}
+	s.savedState = string(data)
+}
+
+// Write the mappings that are dirty or in the middle of syncing to the state file, if it's
+// enabled and they've changed since the last write. Must be called with needsRsyncMutex held,
+// which keeps the file in step with needsRsync.
+func (s *Syncer) saveState() {
+	path := s.config.Settings.StateFile
+	if path == "" {
+		return
+	}
+
+	records := make([]stateRecord, 0)
+	for _, mapping := range s.config.Mappings {
+		if s.needsRsync[mapping] || s.syncing[mapping] {
+			records = append(records, stateRecord{Source: mapping.Source, Target: mapping.Target})
+		}
+	}
+
+	data, err := json.MarshalIndent(records, "", "    ")
+	if err != nil || string(data) == s.savedState {
+		return
+	}
+	if err := writeJSONFile(path, records); err != nil {
+		logging.Errorf("failed to write state file: %v", err)
+		return
+	}
+	s.savedState = string(data)
+}
@@ -0,0 +1,77 @@
+package autorsync
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TransferStats are the transfer metrics parsed from the output of rsync --stats.
+type TransferStats struct {
+	BytesTransferred int64   `json:"bytes_transferred"`
+	FilesTransferred int64   `json:"files_transferred"`
+	BytesPerSecond   float64 `json:"bytes_per_sec"`
+}
+
+var speedPattern = regexp.MustCompile(`([\d.,]+[KMGTP]?) bytes/sec`)
+
+// Parse the summary printed by rsync --stats. rsync 3.x reports "Number of regular files
+// transferred" and separates thousands with commas, while 2.x reports "Number of files
+// transferred". With -h, sizes get a unit suffix, which is in units of 1000 for 3.x and
+// units of 1024 for 2.x.
+func parseTransferStats(output []byte) TransferStats {
+	var stats TransferStats
+	var transferredSize, speed string
+	unit := 1024.0
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case strings.HasPrefix(line, "Number of regular files transferred:"):
+			unit = 1000
+			stats.FilesTransferred = int64(parseRsyncNumber(fieldValue(line), 1))
+		case strings.HasPrefix(line, "Number of files transferred:"):
+			stats.FilesTransferred = int64(parseRsyncNumber(fieldValue(line), 1))
+		case strings.HasPrefix(line, "Total transferred file size:"):
+			transferredSize = strings.TrimSuffix(fieldValue(line), " bytes")
+		case strings.HasPrefix(line, "sent "):
+			if match := speedPattern.FindStringSubmatch(line); match != nil {
+				speed = match[1]
+			}
+		}
+	}
+
+	stats.BytesTransferred = int64(parseRsyncNumber(transferredSize, unit))
+	stats.BytesPerSecond = parseRsyncNumber(speed, unit)
+	return stats
+}
+
+func fieldValue(line string) string {
+	return strings.TrimSpace(line[strings.Index(line, ":")+1:])
+}
+
+// Parse a number as printed by rsync, e.g. "1,234", "12.5K" or "530.00".
+func parseRsyncNumber(s string, unit float64) float64 {
+	s = strings.ReplaceAll(strings.TrimSpace(s), ",", "")
+	if s == "" {
+		return 0
+	}
+
+	multiplier := 1.0
+	if i := strings.IndexAny(s, "KMGTP"); i == len(s)-1 {
+		for _, suffix := range "KMGTP" {
+			multiplier *= unit
+			if rune(s[i]) == suffix {
+				break
+			}
+		}
+		s = s[:i]
+	}
+
+	n, _ := strconv.ParseFloat(s, 64)
+	return n * multiplier
+}
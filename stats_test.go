@@ -0,0 +1,71 @@
+package autorsync
+
+import "testing"
+
+func TestParseTransferStats(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   TransferStats
+	}{
+		{
+			name: "rsync 3",
+			output: `
+Number of files: 1,234 (reg: 1,000, dir: 234)
+Number of created files: 0
+Number of deleted files: 0
+Number of regular files transferred: 1,024
+Total file size: 12,345,678 bytes
+Total transferred file size: 5,242,880 bytes
+Literal data: 5,242,880 bytes
+Matched data: 0 bytes
+
+sent 5,250,000 bytes  received 1,234 bytes  1,050,246.80 bytes/sec
+total size is 12,345,678  speedup is 2.35
+`,
+			want: TransferStats{FilesTransferred: 1024, BytesTransferred: 5242880, BytesPerSecond: 1050246.80},
+		},
+		{
+			name: "rsync 3 with -h",
+			output: `
+Number of regular files transferred: 12
+Total file size: 12.35M bytes
+Total transferred file size: 2.50M bytes
+
+sent 2.51M bytes  received 1.23K bytes  1.25M bytes/sec
+`,
+			want: TransferStats{FilesTransferred: 12, BytesTransferred: 2500000, BytesPerSecond: 1250000},
+		},
+		{
+			name: "rsync 2",
+			output: `
+Number of files: 120
+Number of files transferred: 3
+Total file size: 40960 bytes
+Total transferred file size: 2048 bytes
+
+sent 2150 bytes  received 64 bytes  1476.00 bytes/sec
+`,
+			want: TransferStats{FilesTransferred: 3, BytesTransferred: 2048, BytesPerSecond: 1476},
+		},
+		{
+			name: "rsync 2 with -h",
+			output: `
+Number of files transferred: 3
+Total transferred file size: 2.00K bytes
+
+sent 2.10K bytes  received 64 bytes  1.50K bytes/sec
+`,
+			want: TransferStats{FilesTransferred: 3, BytesTransferred: 2048, BytesPerSecond: 1536},
+		},
+		{name: "no stats", output: "sending incremental file list\n", want: TransferStats{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := parseTransferStats([]byte(test.output)); got != test.want {
+				t.Errorf("parseTransferStats() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package autorsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// Results of the syncs run for a mapping since startup.
+type syncStats struct {
+	lastSyncedAt time.Time
+	lastDuration time.Duration
+	lastExitCode int
+	syncCount    int
+	failedCount  int
+	// Changes seen under the source that weren't excluded.
+	eventCount int
+
+	// Over every run of rsync, which excludes syncs skipped by a failed pre_sync.
+	timedCount    int
+	minDuration   time.Duration
+	maxDuration   time.Duration
+	totalDuration time.Duration
+
+	// Only set when settings.capture_stats is enabled.
+	lastTransfer *TransferStats
+	totalBytes   int64
+}
+
+// StatusRecord is the entry for a single mapping in the status file.
+type StatusRecord struct {
+	Name         string    `json:"name"`
+	Source       string    `json:"source"`
+	Target       string    `json:"target"`
+	LastSyncedAt time.Time `json:"last_synced_at"`
+	LastDuration string    `json:"last_duration"`
+	LastExitCode int       `json:"last_exit_code"`
+	SyncCount    int       `json:"sync_count"`
+	MinDuration  string    `json:"min_duration"`
+	MaxDuration  string    `json:"max_duration"`
+	MeanDuration string    `json:"mean_duration"`
+
+	LastTransfer *TransferStats `json:"last_transfer,omitempty"`
+}
+
+// MappingStatus is the current state of a single mapping.
+type MappingStatus struct {
+	Name         string     `json:"name"`
+	Source       string     `json:"source"`
+	Target       string     `json:"target"`
+	Dirty        bool       `json:"dirty"`
+	LastSyncedAt *time.Time `json:"last_synced_at,omitempty"`
+	// How long rsync ran for in the last sync.
+	LastDuration time.Duration `json:"last_duration_ns"`
+
+	SyncCount       int `json:"sync_count"`
+	FailedSyncCount int `json:"failed_sync_count"`
+	EventCount      int `json:"event_count"`
+	// Total time spent running rsync, over RsyncRuns runs.
+	RsyncRuns     int           `json:"rsync_runs"`
+	RsyncDuration time.Duration `json:"rsync_duration_ns"`
+	// Only counted when settings.capture_stats is enabled.
+	BytesTransferred int64 `json:"bytes_transferred"`
+	// Set while the circuit breaker is holding off syncs after repeated failures.
+	CircuitOpenUntil    *time.Time `json:"circuit_open_until,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+
+	LastTransfer *TransferStats `json:"last_transfer,omitempty"`
+}
+
+// Status is a snapshot of what a Syncer is doing.
+type Status struct {
+	SyncInProgress bool            `json:"sync_in_progress"`
+	Mappings       []MappingStatus `json:"mappings"`
+}
+
+// Status returns the current state of every mapping.
+func (s *Syncer) Status() Status {
+	s.needsRsyncMutex.Lock()
+	defer s.needsRsyncMutex.Unlock()
+
+	status := Status{SyncInProgress: s.syncInProgress}
+	for _, mapping := range s.config.Mappings {
+		ms := MappingStatus{
+			Name:   mapping.label(),
+			Source: mapping.Source,
+			Target: mapping.Target,
+			Dirty:  s.needsRsync[mapping],
+
+			LastDuration: mapping.stats.lastDuration,
+
+			SyncCount:       mapping.stats.syncCount,
+			FailedSyncCount: mapping.stats.failedCount,
+			EventCount:      mapping.stats.eventCount,
+			RsyncRuns:       mapping.stats.timedCount,
+			RsyncDuration:   mapping.stats.totalDuration,
+
+			BytesTransferred: mapping.stats.totalBytes,
+			LastTransfer:     mapping.stats.lastTransfer,
+		}
+		if !mapping.stats.lastSyncedAt.IsZero() {
+			lastSyncedAt := mapping.stats.lastSyncedAt
+			ms.LastSyncedAt = &lastSyncedAt
+		}
+		if time.Now().Before(mapping.circuitOpenUntil) {
+			circuitOpenUntil := mapping.circuitOpenUntil
+			ms.CircuitOpenUntil = &circuitOpenUntil
+		}
+		ms.ConsecutiveFailures = mapping.consecutiveFailures
+		status.Mappings = append(status.Mappings, ms)
+	}
+
+	return status
+}
+
+// Run rsync for mapping and record the outcome in its stats and the status file.
+func (s *Syncer) syncMapping(ctx context.Context, mapping *Mapping) error {
+	// Dry runs don't change the target, so they can't get in another process's way.
+	release := func() {}
+	if !s.options.DryRun {
+		var err error
+		if release, err = lockMapping(ctx, mapping, s.config.Settings.lockTimeout); err != nil {
+			return err
+		}
+	}
+	output, duration, err := s.runRsync(ctx, mapping)
+	release()
+	if errors.Is(err, errSyncTooLarge) || errors.Is(err, errMappingLocked) {
+		// Nothing was synced, so there's no result to record.
+		return err
+	}
+	if s.config.Settings.Notify && !s.options.DryRun {
+		notifySyncResult(mapping, err)
+	}
+
+	s.statusFileMutex.Lock()
+	defer s.statusFileMutex.Unlock()
+
+	s.needsRsyncMutex.Lock()
+	mapping.stats.lastSyncedAt = time.Now()
+	mapping.stats.lastDuration = duration
+	if duration > 0 {
+		mapping.stats.recordDuration(duration)
+	}
+	mapping.stats.lastExitCode = exitCode(err)
+	mapping.stats.syncCount++
+	if err != nil {
+		mapping.stats.failedCount++
+	}
+	if s.config.Settings.CaptureStats && output != nil {
+		transfer := parseTransferStats(output)
+		mapping.stats.lastTransfer = &transfer
+		mapping.stats.totalBytes += transfer.BytesTransferred
+	}
+
+	var records []StatusRecord
+	if s.config.Settings.StatusFile != "" {
+		records = s.statusRecords()
+	}
+	s.needsRsyncMutex.Unlock()
+
+	if records != nil {
+		if err := writeJSONFile(s.config.Settings.StatusFile, records); err != nil {
+			mappingLog(mapping).Errorf("failed to write status file: %v", err)
+		}
+	}
+
+	if s.options.OnSync != nil {
+		s.options.OnSync()
+	}
+	return err
+}
+
+func (st *syncStats) recordDuration(d time.Duration) {
+	if st.timedCount == 0 || d < st.minDuration {
+		st.minDuration = d
+	}
+	if d > st.maxDuration {
+		st.maxDuration = d
+	}
+	st.totalDuration += d
+	st.timedCount++
+}
+
+func (st *syncStats) meanDuration() time.Duration {
+	if st.timedCount == 0 {
+		return 0
+	}
+	return st.totalDuration / time.Duration(st.timedCount)
+}
+
+// Convert an error from running rsync into the exit code to report. Failures to start
+// rsync at all are reported as -1.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// Must be called with needsRsyncMutex held.
+func (s *Syncer) statusRecords() []StatusRecord {
+	records := make([]StatusRecord, 0, len(s.config.Mappings))
+	for _, mapping := range s.config.Mappings {
+		records = append(records, StatusRecord{
+			Name:         mapping.label(),
+			Source:       mapping.Source,
+			Target:       mapping.Target,
+			LastSyncedAt: mapping.stats.lastSyncedAt,
+			LastDuration: mapping.stats.lastDuration.String(),
+			MinDuration:  mapping.stats.minDuration.String(),
+			MaxDuration:  mapping.stats.maxDuration.String(),
+			MeanDuration: mapping.stats.meanDuration().String(),
+			LastExitCode: mapping.stats.lastExitCode,
+			SyncCount:    mapping.stats.syncCount,
+			LastTransfer: mapping.stats.lastTransfer,
+		})
+	}
+	return records
+}
+
+// Write v to path as JSON via a rename so that readers never see a partial file.
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// ReadStatusFile reads the records written to the status file at path.
+func ReadStatusFile(path string) ([]StatusRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read status file: %w", err)
+	}
+
+	var records []StatusRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse status file: %w", err)
+	}
+	return records, nil
+}
@@ -0,0 +1,791 @@
+// Package autorsync monitors directories for changes and uses the system's rsync to update
+// the files on their targets.
+package autorsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dcrodman/autorsync/internal/logging"
+	"github.com/fsnotify/fsnotify"
+)
+
+// Options control how a Syncer runs rsync.
+type Options struct {
+	// rsync executable to run.
+	RsyncPath string
+	// Config files that the Config was read from. These are watched and changes to them are
+	// applied without restarting.
+	ConfigFiles []string
+	// Log rsync commands (with --dry-run added) instead of running them.
+	DryRun bool
+	// Kill rsync if it runs longer than this. Zero means no limit.
+	RsyncTimeout time.Duration
+	// Never run rsync. Instead, a ChangeRecord is written to WatchOutput as a line of JSON
+	// for each mapping when it would have been synced.
+	WatchOnly bool
+	// Defaults to os.Stdout.
+	WatchOutput io.Writer
+	// Sync every mapping as soon as the Syncer starts, to catch up on changes made while it
+	// wasn't running. Ignored with WatchOnly.
+	InitialSync bool
+	// The Config came from ReadConfigWithEnvMappings, so reloads keep the mappings defined by
+	// environment variables.
+	MappingsFromEnv bool
+	// Called after each sync has been recorded in the Status, from the goroutine that ran it.
+	OnSync func()
+}
+
+// Syncer watches the sources of a Config's mappings and rsyncs them to their targets after
+// they change.
+type Syncer struct {
+	config  *Config
+	options Options
+	watcher *watcherSet
+	// Paths added to the watcher. Only used by Start and then the event loop.
+	watched map[string]bool
+	// Runs rsync; runCommand unless replaced for testing.
+	executor executor
+
+	needsRsync        map[*Mapping]bool
+	needsRsyncMutex   sync.Mutex
+	shutdownRequested bool
+	syncInProgress    bool
+	// Mappings taken out of needsRsync for the current round of syncs.
+	syncing map[*Mapping]bool
+	// The dirty mappings last written to the state file.
+	savedState string
+	// When the last file event came in, and whether the rsync loop has stopped ticking
+	// because of settings.idle_after.
+	lastEventAt time.Time
+	idle        bool
+
+	// Wakes the rsync loop when it's idle and a mapping becomes dirty.
+	wake chan struct{}
+
+	// Held for the duration of each round of syncs so that a config reload can't happen mid-sync.
+	syncMutex sync.Mutex
+	// Serializes writes to the status file so that concurrent syncs can't replace a newer
+	// snapshot with an older one.
+	statusFileMutex sync.Mutex
+	// Keeps lines from concurrent syncs from interleaving in the event log.
+	eventLogMutex sync.Mutex
+
+	// Wakes the rsync loop early for SyncNow.
+	forceSync chan struct{}
+	// Requests from AddMapping and RemoveMapping for the event loop.
+	changes chan mappingChange
+
+	shutdown   chan struct{}
+	eventsDone chan struct{}
+	syncDone   chan struct{}
+	stopOnce   sync.Once
+	stopErr    error
+}
+
+// NewSyncer creates a Syncer for config. The Syncer takes ownership of config, which is
+// updated in place if the config file is reloaded.
+func NewSyncer(config *Config, options Options) *Syncer {
+	if options.RsyncPath == "" {
+		options.RsyncPath = "rsync"
+	}
+	if options.WatchOutput == nil {
+		options.WatchOutput = os.Stdout
+	}
+
+	return &Syncer{
+		config:     config,
+		options:    options,
+		executor:   runCommand,
+		needsRsync: make(map[*Mapping]bool),
+		watched:    make(map[string]bool),
+		syncing:    make(map[*Mapping]bool),
+		forceSync:  make(chan struct{}, 1),
+		changes:    make(chan mappingChange),
+		wake:       make(chan struct{}, 1),
+		shutdown:   make(chan struct{}),
+		eventsDone: make(chan struct{}),
+		syncDone:   make(chan struct{}),
+	}
+}
+
+// Start watches every mapping and begins syncing them in the background. The Syncer runs
+// until Stop is called or ctx is cancelled. Unlike Stop, cancelling ctx kills any rsync that's
+// running and leaves pending changes unsynced.
+func (s *Syncer) Start(ctx context.Context) error {
+	if s.watcher != nil {
+		return errors.New("syncer already started")
+	}
+
+	watcher := newWatcherSet()
+	if err := s.watchMappings(watcher); err != nil {
+		watcher.Close()
+		return err
+	}
+	if s.config.Settings.StateFile != "" {
+		s.restoreState()
+	}
+	if s.options.InitialSync && !s.options.WatchOnly {
+		for mapping := range s.needsRsync {
+			s.needsRsync[mapping] = true
+		}
+		s.saveState()
+		// The rsync loop hasn't started yet, so this can't block.
+		s.forceSync <- struct{}{}
+	}
+
+	// The config files are excluded from the mappings, so they need their own watches.
+	for _, configFile := range s.options.ConfigFiles {
+		if err := watcher.Add(configFile); err != nil {
+			logging.Errorf("unable to watch config file %s for changes: %v", configFile, err)
+		}
+	}
+	s.watcher = watcher
+	s.lastEventAt = time.Now()
+
+	checkRemote := s.config.Settings.CheckRemoteOnStart
+	if (checkRemote == nil || *checkRemote) && !s.options.WatchOnly {
+		s.checkRemoteTargets(ctx)
+	}
+
+	go s.startRsyncLoop(ctx)
+	go s.pollForChanges(ctx)
+	go func() {
+		s.waitForSyncEvents(ctx)
+		close(s.eventsDone)
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Stop()
+		case <-s.syncDone:
+		}
+	}()
+
+	return nil
+}
+
+// Stop finishes any pending syncs, then stops watching. It blocks until the last round of
+// syncs is complete.
+func (s *Syncer) Stop() error {
+	if s.watcher == nil {
+		return errors.New("syncer not started")
+	}
+
+	s.stopOnce.Do(func() {
+		close(s.shutdown)
+		<-s.eventsDone
+
+		s.needsRsyncMutex.Lock()
+		s.shutdownRequested = true
+		s.needsRsyncMutex.Unlock()
+		// Run the last round now rather than on the next tick, which could be a long way off.
+		s.requestSync()
+		<-s.syncDone
+
+		s.stopErr = s.watcher.Close()
+	})
+	return s.stopErr
+}
+
+// SyncNow marks every mapping as changed and syncs them straight away instead of waiting for
+// the next tick, for changes that were made without generating any file events.
+func (s *Syncer) SyncNow() {
+	s.needsRsyncMutex.Lock()
+	for mapping := range s.needsRsync {
+		s.needsRsync[mapping] = true
+	}
+	s.saveState()
+	s.needsRsyncMutex.Unlock()
+	s.requestSync()
+}
+
+// SyncMappingNow is like SyncNow, but only for the mappings with the given name, which is the
+// Name reported for them by Status.
+func (s *Syncer) SyncMappingNow(name string) error {
+	s.needsRsyncMutex.Lock()
+	found := false
+	for mapping := range s.needsRsync {
+		if mapping.label() == name {
+			s.needsRsync[mapping] = true
+			found = true
+		}
+	}
+	if found {
+		s.saveState()
+	}
+	s.needsRsyncMutex.Unlock()
+
+	if !found {
+		return fmt.Errorf("no mapping named %s", name)
+	}
+	s.requestSync()
+	return nil
+}
+
+// Start a round of syncs without waiting for the next tick. A round that's already been
+// requested will pick up the changes too.
+func (s *Syncer) requestSync() {
+	select {
+	case s.forceSync <- struct{}{}:
+	default:
+	}
+}
+
+// SyncAll syncs every enabled mapping once without watching for changes, returning an error
+// if any of them failed. Cancelling ctx kills the rsync that's running and skips the rest.
+func (s *Syncer) SyncAll(ctx context.Context) error {
+	failed := 0
+	for _, mapping := range s.mappingsByPriority() {
+		if !mapping.isEnabled() {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := s.syncMapping(ctx, mapping); err != nil {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d syncs failed", failed, len(s.config.Mappings))
+	}
+	return nil
+}
+
+// Watch every mapping for Start, giving up if it takes longer than settings.watch_init_timeout.
+func (s *Syncer) watchMappings(watcher *watcherSet) error {
+	done := make(chan error, 1)
+	go func() {
+		for _, mapping := range s.config.Mappings {
+			logMappingStart(mapping)
+			if err := s.watchOrPoll(watcher, mapping); err != nil {
+				done <- err
+				return
+			}
+			s.needsRsync[mapping] = false
+		}
+		done <- nil
+	}()
+
+	timeout := s.config.Settings.watchTimeout
+	if timeout == 0 {
+		return <-done
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		// Adding watches fails once the watcher is closed, which ends the walk.
+		watcher.Close()
+		<-done
+		return fmt.Errorf("gave up watching sources after watch_init_timeout (%s), with %d paths watched; "+
+			"exclude directories that don't need syncing or raise the timeout", timeout, len(s.watched))
+	}
+}
+
+// Re-read the config file and apply any changes to the running process. Mappings that
+// are unchanged keep their state, removed mappings stop being watched and new mappings
+// start being watched. Returns the mappings that were removed.
+func (s *Syncer) reloadConfig() []*Mapping {
+	readConfig := ReadConfig
+	if s.options.MappingsFromEnv {
+		readConfig = ReadConfigWithEnvMappings
+	}
+	newConfig, err := readConfig(s.options.ConfigFiles...)
+	if err != nil {
+		logging.Errorf("not reloading config: %v", err)
+		return nil
+	}
+	logging.SetLevel(newConfig.Settings.LogLevel)
+
+	// Any in-progress sync finishes against the old config first.
+	logging.Debugf("waiting for the sync lock to reload the config")
+	s.syncMutex.Lock()
+	defer s.syncMutex.Unlock()
+	defer logging.Debugf("released the sync lock after reloading the config")
+	s.needsRsyncMutex.Lock()
+	defer s.needsRsyncMutex.Unlock()
+
+	// Merged config files can define the same mapping more than once, so each key can have
+	// several mappings.
+	existing := make(map[string][]*Mapping)
+	for _, mapping := range s.config.Mappings {
+		key := mappingKey(mapping)
+		existing[key] = append(existing[key], mapping)
+	}
+
+	var mappings, kept, added []*Mapping
+	sources := make(map[string]bool)
+	for _, mapping := range newConfig.Mappings {
+		sources[mapping.Source] = true
+
+		key := mappingKey(mapping)
+		if olds := existing[key]; len(olds) > 0 {
+			old := olds[0]
+			// The global interval may have changed even if the mapping didn't.
+			old.refreshInterval = mapping.refreshInterval
+			mappings = append(mappings, old)
+			kept = append(kept, old)
+			existing[key] = olds[1:]
+		} else {
+			mappings = append(mappings, mapping)
+			added = append(added, mapping)
+		}
+	}
+
+	// Unwatch before watching so that a new mapping sharing a source with a removed one
+	// doesn't have its watches dropped.
+	var removed []*Mapping
+	var unwatched []string
+	for _, olds := range existing {
+		for _, mapping := range olds {
+			mappingLog(mapping).Infof("no longer syncing %s", mapping.label())
+			// Kept mappings with the same source still need its watches.
+			if !sources[mapping.Source] {
+				unwatchFilesInDirectory(s.watcher, s.watched, mapping.Source)
+				unwatched = append(unwatched, mapping.Source)
+			}
+			delete(s.needsRsync, mapping)
+			removed = append(removed, mapping)
+		}
+	}
+
+	// Nested sources share watches, so put back any that a kept mapping still needs.
+	for _, mapping := range kept {
+		if mapping.polling {
+			continue
+		}
+		for _, source := range unwatched {
+			if isWithin(mapping.Source, source) || isWithin(source, mapping.Source) {
+				if err := watchFilesInDirectory(s.watcher, s.watched, mapping, mapping.Source); err != nil {
+					mappingLog(mapping).Errorf("%v", err)
+				}
+				break
+			}
+		}
+	}
+
+	for _, mapping := range added {
+		logMappingStart(mapping)
+		if err := s.watchOrPoll(s.watcher, mapping); err != nil {
+			mappingLog(mapping).Errorf("%v", err)
+		}
+		s.needsRsync[mapping] = false
+	}
+
+	s.config.Settings = newConfig.Settings
+	s.config.Mappings = mappings
+	s.saveState()
+	logging.Infof("reloaded config from %s", strings.Join(s.options.ConfigFiles, ", "))
+
+	return removed
+}
+
+// Log messages about mapping with its source and target attached.
+func mappingLog(mapping *Mapping) logging.Logger {
+	return logging.ForMapping(mapping.Name, mapping.Source, mapping.Target)
+}
+
+func logMappingStart(mapping *Mapping) {
+	description := mapping.label()
+	if mapping.Name != "" {
+		description += fmt.Sprintf(" (%s → %s)", mapping.Source, mapping.Target)
+	}
+
+	if mapping.isEnabled() {
+		mappingLog(mapping).Infof("syncing %s", description)
+	} else {
+		mappingLog(mapping).Infof("not syncing %s (disabled)", description)
+	}
+}
+
+// Identify a mapping by its configured values so that it can be matched across reloads.
+// Settings that are resolved onto the mapping and change how it's watched are included so
+// that changing them globally re-watches the mapping.
+func mappingKey(m *Mapping) string {
+	key, _ := json.Marshal(struct {
+		*Mapping
+		Gitignore      bool
+		FollowSymlinks bool
+		HiddenFiles    bool
+	}{m, m.gitignore, m.followSymlinks, m.hiddenFiles})
+	return string(key)
+}
+
+// Wait for events from fsnotify on any of the files we watched. Once shutdown is closed, any
+// events already queued are processed and pending debounce timers are flushed before returning.
+// Cancelling ctx returns straight away.
+func (s *Syncer) waitForSyncEvents(ctx context.Context) {
+	debounceTimers := make(map[*Mapping]*time.Timer)
+	configPaths := make(map[string]string)
+	for _, configFile := range s.options.ConfigFiles {
+		configPath, _ := filepath.Abs(configFile)
+		configPaths[configPath] = configFile
+	}
+
+	handleEvent := func(event fsnotify.Event) {
+		logging.Logger{}.WithEventPath(event.Name).Debugf("file event %s", event)
+		eventPath, _ := filepath.Abs(event.Name)
+		if configFile, ok := configPaths[eventPath]; ok {
+			logging.Logger{}.WithEventPath(event.Name).Eventf("detected change to config file %s", event.Name)
+			// Editors that save by replacing the file drop the original watch.
+			if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				s.watcher.Add(configFile)
+			}
+
+			for _, mapping := range s.reloadConfig() {
+				if timer, ok := debounceTimers[mapping]; ok {
+					timer.Stop()
+					delete(debounceTimers, mapping)
+				}
+			}
+			return
+		}
+
+		if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+			forgetWatches(s.watched, filepath.Clean(event.Name), event.Op&fsnotify.Rename != 0)
+			s.checkSourceRemoved(filepath.Clean(event.Name))
+		}
+
+		debounce := time.Duration(s.config.Settings.DebounceMs) * time.Millisecond
+
+		// Mappings can share a source or nest inside each other, and each of them that the
+		// path is in needs syncing.
+		for _, mapping := range s.config.Mappings {
+			if !isWithin(event.Name, mapping.Source) || mapping.missing || !mapping.isEnabled() {
+				continue
+			}
+			// Excluded files can still generate events through their parent directory's watch.
+			if isExcluded(mapping, event.Name, isDirectory(event.Name)) {
+				continue
+			}
+			mappingLog(mapping).WithEventPath(event.Name).Eventf("detected change to %s", event.Name)
+			s.needsRsyncMutex.Lock()
+			mapping.stats.eventCount++
+			mapping.lastEventPath = event.Name
+			s.lastEventAt = time.Now()
+			s.needsRsyncMutex.Unlock()
+
+			// Like the config file, a single-file source loses its watch when it's replaced.
+			if event.Name == mapping.Source && event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 && isRegularFile(mapping.Source) {
+				addWatch(s.watcher, s.watched, mapping.Source)
+			}
+			// fsnotify doesn't watch directories created after the initial walk, so pick them up
+			// (and anything already made inside them, as with mkdir -p) here.
+			if event.Op&fsnotify.Create != 0 && isDirectory(event.Name) {
+				if err := watchFilesInDirectory(s.watcher, s.watched, mapping, filepath.Clean(event.Name)); err != nil {
+					mappingLog(mapping).WithEventPath(event.Name).Errorf("failed to watch %s: %v", event.Name, err)
+				}
+			}
+
+			if debounce <= 0 {
+				s.markNeedsRsync(mapping)
+			} else if timer, ok := debounceTimers[mapping]; ok {
+				// Further events inside the window just push the deadline back.
+				timer.Reset(debounce)
+			} else {
+				m := mapping
+				debounceTimers[mapping] = time.AfterFunc(debounce, func() { s.markNeedsRsync(m) })
+			}
+		}
+	}
+
+	for {
+		select {
+		case event := <-s.watcher.Events:
+			handleEvent(event)
+		case err := <-s.watcher.Errors:
+			logging.Errorf("%v", err)
+		case change := <-s.changes:
+			switch {
+			case change.add != nil:
+				change.result <- s.addMapping(change.add)
+			case change.created != nil:
+				change.result <- s.watchCreatedSource(change.created)
+			default:
+				removed, err := s.removeMappings(change.remove)
+				for _, mapping := range removed {
+					if timer, ok := debounceTimers[mapping]; ok {
+						timer.Stop()
+						delete(debounceTimers, mapping)
+					}
+				}
+				change.result <- err
+			}
+		case <-ctx.Done():
+			for _, timer := range debounceTimers {
+				timer.Stop()
+			}
+			return
+		case <-s.shutdown:
+			for {
+				select {
+				case event := <-s.watcher.Events:
+					handleEvent(event)
+				default:
+					// Don't wait out the debounce window for changes that have already happened.
+					for mapping, timer := range debounceTimers {
+						if timer.Stop() {
+							s.markNeedsRsync(mapping)
+						}
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// Flag mapping as having changes that need to be synced on the next tick.
+func (s *Syncer) markNeedsRsync(mapping *Mapping) {
+	s.needsRsyncMutex.Lock()
+	s.setNeedsRsync(mapping)
+	s.needsRsyncMutex.Unlock()
+}
+
+// Must be called with needsRsyncMutex held.
+func (s *Syncer) setNeedsRsync(mapping *Mapping) {
+	// Mappings removed by a config reload are no longer in the map and shouldn't come back.
+	if _, ok := s.needsRsync[mapping]; ok {
+		s.needsRsync[mapping] = true
+		mapping.oversized = false
+		s.saveState()
+
+		if s.idle {
+			s.idle = false
+			select {
+			case s.wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// Listen for requests to update directories and update any affected targets. After
+// shutdownRequested is set, the next tick syncs anything still dirty and closes syncDone.
+// SyncNow starts a round early. Once ctx is cancelled nothing more is synced.
+func (s *Syncer) startRsyncLoop(ctx context.Context) {
+	interval := tickInterval(s.config)
+	ticker := time.NewTicker(interval)
+	var limiter *rateLimiter
+
+	for {
+		forced := false
+		if s.goIdle() {
+			ticker.Stop()
+			logging.Infof("no changes for %s, pausing until the next one", s.config.Settings.idleAfter)
+			select {
+			case <-s.wake:
+			case <-s.forceSync:
+				forced = true
+			case <-s.shutdown:
+			case <-ctx.Done():
+				close(s.syncDone)
+				return
+			}
+			s.needsRsyncMutex.Lock()
+			s.idle = false
+			s.needsRsyncMutex.Unlock()
+			ticker = time.NewTicker(interval)
+		}
+
+		if !forced {
+			select {
+			case <-ticker.C:
+			case <-s.forceSync:
+				forced = true
+			case <-ctx.Done():
+				// Cancelling skips whatever is still waiting to be synced.
+				ticker.Stop()
+				close(s.syncDone)
+				return
+			}
+		}
+		s.syncMutex.Lock()
+		s.needsRsyncMutex.Lock()
+
+		// Pick up interval changes from a config reload.
+		if newInterval := tickInterval(s.config); newInterval != interval {
+			interval = newInterval
+			ticker.Stop()
+			ticker = time.NewTicker(interval)
+		}
+		if perMinute := s.config.Settings.MaxSyncsPerMinute; perMinute == 0 {
+			limiter = nil
+		} else if limiter == nil || limiter.perMinute != perMinute {
+			limiter = newRateLimiter(perMinute)
+		}
+
+		// Take the dirty mappings and release the lock while rsync runs so that events and
+		// status requests aren't blocked behind it. Mappings with a longer interval than the
+		// loop only get a turn once their own interval has passed. They're synced in priority
+		// order, which also decides who gets to go first under max_syncs_per_minute.
+		now := time.Now()
+		var dirty []*Mapping
+		for _, mapping := range s.mappingsByPriority() {
+			needsSync, ok := s.needsRsync[mapping]
+			if !ok {
+				continue
+			}
+			if now.Before(mapping.nextSyncAt) && !s.shutdownRequested && !forced {
+				continue
+			}
+			mapping.nextSyncAt = now.Add(mapping.refreshInterval)
+
+			// Failed syncs wait out their backoff and any open circuit breaker, except for one
+			// last try when shutting down.
+			if (now.Before(mapping.retryAt) || now.Before(mapping.circuitOpenUntil)) && !s.shutdownRequested && !forced {
+				continue
+			}
+
+			if !needsSync || !mapping.isEnabled() || mapping.missing || ctx.Err() != nil {
+				continue
+			}
+			if mapping.oversized && !forced {
+				continue
+			}
+
+			// Over the rate limit, the mapping stays dirty and tries again on the next tick.
+			if limiter != nil && !s.shutdownRequested && !limiter.allow(now) {
+				if !mapping.rateLimited {
+					mappingLog(mapping).Infof("max_syncs_per_minute reached, delaying sync of %s", mapping.label())
+					mapping.rateLimited = true
+				}
+				mapping.nextSyncAt = time.Time{}
+				continue
+			}
+			mapping.rateLimited = false
+
+			dirty = append(dirty, mapping)
+			s.needsRsync[mapping] = false
+			s.syncing[mapping] = true
+		}
+		s.syncInProgress = len(dirty) > 0
+		s.needsRsyncMutex.Unlock()
+
+		if s.options.WatchOnly {
+			s.reportChanges(dirty)
+			s.needsRsyncMutex.Lock()
+			for _, mapping := range dirty {
+				delete(s.syncing, mapping)
+			}
+			s.saveState()
+			s.needsRsyncMutex.Unlock()
+			dirty = nil
+		}
+
+		if len(dirty) > 0 {
+			labels := make([]string, len(dirty))
+			for i, mapping := range dirty {
+				labels[i] = mapping.label()
+			}
+			logging.Debugf("holding the sync lock to sync %s", strings.Join(labels, ", "))
+		}
+		// Mappings are independent, so up to max_concurrent_syncs of them can run at once.
+		var wg sync.WaitGroup
+		slots := make(chan struct{}, s.config.Settings.MaxConcurrentSyncs)
+		for _, mapping := range dirty {
+			slots <- struct{}{}
+			wg.Add(1)
+			m := mapping
+			go func() {
+				defer wg.Done()
+				s.recordSyncResult(m, s.syncMapping(ctx, m))
+				s.needsRsyncMutex.Lock()
+				delete(s.syncing, m)
+				s.saveState()
+				s.needsRsyncMutex.Unlock()
+				<-slots
+			}()
+		}
+		wg.Wait()
+
+		s.needsRsyncMutex.Lock()
+		s.syncInProgress = false
+		stop := s.shutdownRequested
+		s.needsRsyncMutex.Unlock()
+		s.syncMutex.Unlock()
+		if len(dirty) > 0 {
+			logging.Debugf("released the sync lock")
+		}
+
+		if stop {
+			ticker.Stop()
+			close(s.syncDone)
+			return
+		}
+	}
+}
+
+// The mappings in the order they should be synced: by priority, then in config order. Must be
+// called with needsRsyncMutex held once the Syncer is running.
+func (s *Syncer) mappingsByPriority() []*Mapping {
+	mappings := append([]*Mapping(nil), s.config.Mappings...)
+	sort.SliceStable(mappings, func(i, j int) bool {
+		return mappings[i].Priority < mappings[j].Priority
+	})
+	return mappings
+}
+
+// Report whether the rsync loop should stop ticking: settings.idle_after has passed since the
+// last file event, nothing is waiting to be synced and the Syncer isn't shutting down.
+func (s *Syncer) goIdle() bool {
+	s.needsRsyncMutex.Lock()
+	defer s.needsRsyncMutex.Unlock()
+
+	idleAfter := s.config.Settings.idleAfter
+	if idleAfter == 0 || time.Since(s.lastEventAt) < idleAfter {
+		return false
+	}
+	for _, dirty := range s.needsRsync {
+		if dirty {
+			return false
+		}
+	}
+	select {
+	case <-s.shutdown:
+		return false
+	default:
+	}
+
+	s.idle = true
+	return true
+}
+
+// The loop ticks at the shortest interval of any mapping.
+func tickInterval(config *Config) time.Duration {
+	interval := config.Settings.refreshInterval
+	for _, mapping := range config.Mappings {
+		if mapping.refreshInterval < interval {
+			interval = mapping.refreshInterval
+		}
+	}
+	return interval
+}
+
+// Short label for the mapping in logs and status output: its name if it has one, otherwise
+// its source and target.
+func (m *Mapping) label() string {
+	if m.Name != "" {
+		return m.Name
+	}
+	return m.Source + " → " + m.Target
+}
+
+func (m *Mapping) isEnabled() bool {
+	return m.Enabled == nil || *m.Enabled
+}
@@ -0,0 +1,197 @@
+package autorsync
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Read a config file with settings and mappings, and start a Syncer for it that runs rsync
+// through fake. The Syncer is stopped when the test ends.
+func startTestSyncer(t *testing.T, ctx context.Context, settings map[string]interface{}, mappings []map[string]interface{}, fake *fakeExecutor) *Syncer {
+	t.Helper()
+	configFile := filepath.Join(t.TempDir(), "autorsync.json")
+	data, _ := json.Marshal(map[string]interface{}{"settings": settings, "mappings": mappings})
+	if err := os.WriteFile(configFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := ReadConfig(configFile)
+	if err != nil {
+		t.Fatalf("ReadConfig: %v", err)
+	}
+
+	s := NewSyncer(config, Options{ConfigFiles: []string{configFile}})
+	s.executor = fake.run
+	if err := s.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { s.Stop() })
+	return s
+}
+
+// The targets that rsync has been run for so far.
+func (f *fakeExecutor) targets() map[string]int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	targets := make(map[string]int)
+	for _, call := range f.calls {
+		targets[call[len(call)-1]]++
+	}
+	return targets
+}
+
+// Wait for rsync to have been run for every one of want.
+func waitForTargets(t *testing.T, fake *fakeExecutor, want ...string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		targets := fake.targets()
+		missing := false
+		for _, target := range want {
+			if targets[target] == 0 {
+				missing = true
+			}
+		}
+		if !missing {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("rsync ran for %v, want %v", targets, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestChangesReachEveryMatchingMapping(t *testing.T) {
+	source := t.TempDir()
+	nested := filepath.Join(source, "a")
+	sibling := source + "2"
+	for _, dir := range []string{nested, sibling} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fake := &fakeExecutor{}
+	startTestSyncer(t, context.Background(), map[string]interface{}{"interval": "50ms"}, []map[string]interface{}{
+		{"source": source, "target": "/outer"},
+		{"source": source, "target": "/disabled", "enabled": false},
+		{"source": source, "target": "/same-source"},
+		{"source": source, "target": "/excluded", "exclusions": []string{"a"}},
+		{"source": nested, "target": "/nested"},
+		{"source": sibling, "target": "/sibling"},
+	}, fake)
+
+	if err := os.WriteFile(filepath.Join(nested, "f"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitForTargets(t, fake, "/outer", "/same-source", "/nested")
+	// Give any stray syncs time to show up.
+	time.Sleep(200 * time.Millisecond)
+	targets := fake.targets()
+	for _, target := range []string{"/disabled", "/excluded", "/sibling"} {
+		if targets[target] != 0 {
+			t.Errorf("rsync ran for %s, which the change isn't in", target)
+		}
+	}
+
+	// A directory whose name starts with the source's isn't in it.
+	fake.mu.Lock()
+	fake.calls = nil
+	fake.mu.Unlock()
+	if err := os.WriteFile(filepath.Join(sibling, "f"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitForTargets(t, fake, "/sibling")
+	time.Sleep(200 * time.Millisecond)
+	if targets := fake.targets(); targets["/outer"] != 0 {
+		t.Errorf("rsync ran for /outer after a change to %s", sibling)
+	}
+}
+
+func TestStopSyncsWithoutWaitingForTick(t *testing.T) {
+	source := t.TempDir()
+	fake := &fakeExecutor{}
+	s := startTestSyncer(t, context.Background(), map[string]interface{}{"interval": "1h"},
+		[]map[string]interface{}{{"source": source, "target": "/backup"}}, fake)
+
+	if err := os.WriteFile(filepath.Join(source, "f"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); !s.Status().Mappings[0].Dirty; {
+		if time.Now().After(deadline) {
+			t.Fatal("the change was never seen")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	start := time.Now()
+	s.Stop()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Stop took %s", elapsed)
+	}
+	if targets := fake.targets(); targets["/backup"] != 1 {
+		t.Errorf("rsync ran %d times on Stop, want the pending change synced once", targets["/backup"])
+	}
+}
+
+func TestCancelStopsWithoutWaitingForTick(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	fake := &fakeExecutor{}
+	s := startTestSyncer(t, ctx, map[string]interface{}{"interval": "1h"},
+		[]map[string]interface{}{{"source": t.TempDir(), "target": "/backup"}}, fake)
+
+	cancel()
+	select {
+	case <-s.syncDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the rsync loop was still running 5s after the context was cancelled")
+	}
+}
+
+func TestOversizedMappingWaitsForNextChange(t *testing.T) {
+	source := t.TempDir()
+	fake := &fakeExecutor{respond: func(ctx context.Context, args []string) ([]byte, error) {
+		return []byte("Number of files transferred: 5\n"), nil
+	}}
+	s := startTestSyncer(t, context.Background(), map[string]interface{}{"interval": "20ms"},
+		[]map[string]interface{}{{"source": source, "target": "/backup", "max_sync_files": 1}}, fake)
+
+	write := func() {
+		if err := os.WriteFile(filepath.Join(source, "f"), []byte(time.Now().String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForCalls := func(n int) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); fake.targets()["/backup"] < n; {
+			if time.Now().After(deadline) {
+				t.Fatalf("rsync ran %d times, want %d", fake.targets()["/backup"], n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	write()
+	waitForCalls(1)
+	// Many ticks later, the same changes haven't been measured again.
+	time.Sleep(300 * time.Millisecond)
+	if n := fake.targets()["/backup"]; n != 1 {
+		t.Fatalf("rsync ran %d times without a new change, want 1", n)
+	}
+	if !s.Status().Mappings[0].Dirty {
+		t.Error("the oversized mapping isn't pending any more")
+	}
+
+	write()
+	waitForCalls(2)
+
+	// Forcing a sync measures them again too.
+	time.Sleep(100 * time.Millisecond)
+	n := fake.targets()["/backup"]
+	s.SyncNow()
+	waitForCalls(n + 1)
+}
@@ -0,0 +1,67 @@
+package autorsync
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// How rsync reaches a mapping's target.
+type targetKind int
+
+const (
+	targetLocal targetKind = iota
+	targetSSH
+	targetDaemon
+)
+
+// Work out how rsync reaches the mapping's target: a daemon when rsync_module is set or the
+// target uses the host::module or rsync:// syntax, ssh for other host:path targets, and
+// otherwise the local filesystem.
+func (m *Mapping) targetKind() targetKind {
+	if m.RsyncModule != "" || strings.HasPrefix(m.Target, "rsync://") {
+		return targetDaemon
+	}
+	if colon := hostColon(m.Target); colon > 0 && strings.HasPrefix(m.Target[colon:], "::") {
+		return targetDaemon
+	}
+	if remoteHost(m.Target) != "" {
+		return targetSSH
+	}
+	return targetLocal
+}
+
+// Fill in the placeholders in mapping's target that can change from one sync to the next:
+// {hostname}, {date}, {datetime} and {mapping_name}.
+func expandTargetTemplate(mapping *Mapping, now time.Time) string {
+	if !strings.Contains(mapping.Target, "{") {
+		return mapping.Target
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		mappingLog(mapping).Warnf("leaving {hostname} in the target of %s: %v", mapping.label(), err)
+		hostname = "{hostname}"
+	}
+	return strings.NewReplacer(
+		"{hostname}", hostname,
+		"{date}", now.Format("2006-01-02"),
+		"{datetime}", now.Format(time.RFC3339),
+		"{mapping_name}", mapping.Name,
+	).Replace(mapping.Target)
+}
+
+// Build the rsync:// URL for a mapping with rsync_module set, whose target is then
+// [user@]host[:path] on the daemon's side.
+func daemonTarget(target, module string) string {
+	host, path := target, ""
+	if colon := hostColon(target); colon >= 0 {
+		host, path = target[:colon], strings.TrimPrefix(target[colon+1:], "/")
+	}
+
+	url := "rsync://" + host + "/" + module
+	if path != "" {
+		url += "/" + path
+	}
+	return url
+}
@@ -0,0 +1,124 @@
+package autorsync
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Returned when verify_checksums finds files on the target that don't match the source.
+var errChecksumMismatch = errors.New("synced files don't match the source")
+
+// Compare the SHA-256 hash of every file in mapping.Source with its copy on the target, hashing
+// them in parallel. Files that aren't on the target are skipped, since rsync's filters may
+// have left them out on purpose.
+func verifyChecksums(mapping *Mapping) error {
+	type file struct{ source, target string }
+	files := make(chan file)
+	var mismatches []string
+	var mismatchesMutex sync.Mutex
+
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range files {
+				if err := compareFiles(f.source, f.target); err != nil {
+					mappingLog(mapping).WithEventPath(f.source).Errorf("checksum verification failed: %v", err)
+					mismatchesMutex.Lock()
+					mismatches = append(mismatches, f.source)
+					mismatchesMutex.Unlock()
+				}
+			}
+		}()
+	}
+
+	targetRoot := syncedPath(mapping)
+	walkErr := filepath.Walk(mapping.Source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if isExcluded(mapping, filepath.Clean(path), info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(mapping.Source, path)
+		if err != nil {
+			return err
+		}
+		files <- file{path, filepath.Join(targetRoot, relPath)}
+		return nil
+	})
+	close(files)
+	wg.Wait()
+
+	if walkErr != nil {
+		return fmt.Errorf("checksum verification failed: %w", walkErr)
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%w: %d mismatched", errChecksumMismatch, len(mismatches))
+	}
+	return nil
+}
+
+// Where rsync puts mapping.Source on a local target. Like cp, a source directory without a
+// trailing slash is copied into the target rather than over it.
+func syncedPath(mapping *Mapping) string {
+	target := mapping.syncTarget
+	if strings.HasSuffix(mapping.Source, "/") {
+		return target
+	}
+	if isRegularFile(mapping.Source) && !isDirectory(target) {
+		return target
+	}
+	return filepath.Join(target, filepath.Base(mapping.Source))
+}
+
+// Return an error if target exists and its contents differ from source.
+func compareFiles(source, target string) error {
+	targetHash, err := hashFile(target)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	sourceHash, err := hashFile(source)
+	if err != nil {
+		return err
+	}
+	if sourceHash != targetHash {
+		return fmt.Errorf("%s doesn't match %s", target, source)
+	}
+	return nil
+}
+
+func hashFile(path string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+
+	f, err := os.Open(path)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
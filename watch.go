@@ -0,0 +1,280 @@
+package autorsync
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Traverse root, which is mapping.Source or a directory somewhere under it, adding any files
+// and subdirectories to the watcher that are not excluded. When the mapping respects .gitignore
+// files, the rules from each one found are collected along the way. A source that's a single
+// file is watched on its own. Paths already in watched, such as ones shared with another
+// mapping, aren't added again.
+func watchFilesInDirectory(watcher *watcherSet, watched map[string]bool, mapping *Mapping, root string) error {
+	if root == mapping.Source {
+		mapping.ignoreRules = nil
+	}
+	if isRegularFile(root) {
+		return addWatch(watcher, watched, root)
+	}
+
+	// Real paths of the directories walked so far, to avoid looping on circular symlinks.
+	visited := make(map[string]bool)
+
+	var walkFn filepath.WalkFunc
+	walkFn = func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		path = filepath.Clean(path)
+
+		if isExcluded(mapping, path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Changes to a directory's entries are reported through the directory above it, so
+		// directories at max_depth are neither walked nor watched themselves.
+		if info.IsDir() && mapping.MaxDepth > 0 && pathDepth(mapping, path) >= mapping.MaxDepth {
+			return filepath.SkipDir
+		}
+
+		if mapping.followSymlinks && info.Mode()&os.ModeSymlink != 0 && isDirectory(path+string(filepath.Separator)) {
+			// The trailing separator makes Walk descend through the link while keeping paths
+			// under mapping.Source, so events still match the mapping.
+			return filepath.Walk(path+string(filepath.Separator), walkFn)
+		}
+
+		if info.IsDir() && mapping.followSymlinks {
+			realPath, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				mappingLog(mapping).Errorf("failed to resolve %s: %v", path, err)
+				return filepath.SkipDir
+			}
+			if visited[realPath] {
+				return filepath.SkipDir
+			}
+			visited[realPath] = true
+		}
+
+		// Read a directory's .gitignore before walking its contents so it applies to all of them.
+		if info.IsDir() && mapping.gitignore {
+			base, _ := filepath.Rel(mapping.Source, path)
+			rules, err := readIgnoreFile(filepath.Join(path, ".gitignore"), base)
+			if err != nil {
+				mappingLog(mapping).Errorf("failed to read .gitignore: %v", err)
+			}
+			mapping.ignoreRules = append(mapping.ignoreRules, rules...)
+		}
+
+		return addWatch(watcher, watched, path)
+	}
+
+	if err := filepath.Walk(root, walkFn); err != nil {
+		return fmt.Errorf("error while traversing directory: %w", err)
+	}
+
+	return nil
+}
+
+func addWatch(watcher *watcherSet, watched map[string]bool, path string) error {
+	if watched[path] {
+		return nil
+	}
+	if err := watcher.Add(path); err != nil {
+		// inotify reports hitting the per-user watch limit as "no space left on device".
+		if errors.Is(err, syscall.ENOSPC) {
+			return fmt.Errorf("ran out of inotify watches at %s; raise the limit with "+
+				"`sudo sysctl fs.inotify.max_user_watches=524288` (add it to /etc/sysctl.conf to keep it across reboots)", path)
+		}
+		return err
+	}
+	watched[path] = true
+	return nil
+}
+
+// Report whether path, which is somewhere under mapping.Source, matches any of the mapping's
+// exclusions, exclusion regexes, extension filters or ignore rules, or is hidden when hidden
+// files aren't watched. Exclusions containing glob
+// characters are matched as patterns, everything else is treated as a literal path prefix.
+func isExcluded(mapping *Mapping, path string, isDir bool) bool {
+	basePath := mapping.Source
+
+	if relPath, err := filepath.Rel(basePath, path); err == nil && isIgnored(mapping.ignoreRules, relPath, isDir) {
+		return true
+	}
+
+	if !isDir && !hasAllowedExtension(mapping, path) {
+		return true
+	}
+
+	if relPath, err := filepath.Rel(basePath, path); err == nil && relPath != "." {
+		if !mapping.hiddenFiles && isHidden(relPath) {
+			return true
+		}
+		for _, re := range mapping.exclusionRegexes {
+			if re.MatchString(filepath.ToSlash(relPath)) {
+				return true
+			}
+		}
+	}
+
+	for _, exclusion := range mapping.Exclusions {
+		if isGlob(exclusion) {
+			if relPath, err := filepath.Rel(basePath, path); err == nil && matchesGlob(exclusion, relPath) {
+				return true
+			}
+			continue
+		}
+
+		// path is always prefixed with the top-level directory path from mapper.Source (basePath), so
+		// to make comparison simple the excluded dirs are made relative to the base path.
+		if !strings.HasPrefix(exclusion, basePath) {
+			exclusion = filepath.Join(basePath, exclusion)
+		}
+		if strings.HasPrefix(path, exclusion) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Report whether any component of relPath starts with a dot.
+func isHidden(relPath string) bool {
+	for _, name := range strings.Split(filepath.ToSlash(relPath), "/") {
+		if strings.HasPrefix(name, ".") && name != "." && name != ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// Deleted paths report false.
+func isDirectory(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.IsDir()
+}
+
+// Follows symlinks, unlike isDirectory. Deleted paths report false.
+func isRegularFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+func isGlob(exclusion string) bool {
+	return strings.ContainsAny(exclusion, "*?[")
+}
+
+// Match a glob exclusion against a path relative to the mapping source, following rsync's
+// rules closely enough for the watcher: a pattern without a slash matches the last path
+// component, a leading "/" anchors the pattern to the source and a leading "**/" lets it
+// match starting at any directory.
+func matchesGlob(pattern, relPath string) bool {
+	pattern = strings.TrimSuffix(pattern, "/")
+
+	if strings.HasPrefix(pattern, "/") {
+		matched, _ := filepath.Match(strings.TrimPrefix(pattern, "/"), relPath)
+		return matched
+	}
+
+	anyDepth := strings.HasPrefix(pattern, "**/")
+	pattern = strings.TrimPrefix(pattern, "**/")
+
+	if !strings.Contains(pattern, "/") {
+		matched, _ := filepath.Match(pattern, filepath.Base(relPath))
+		return matched
+	}
+
+	if !anyDepth {
+		matched, _ := filepath.Match(pattern, relPath)
+		return matched
+	}
+
+	components := strings.Split(relPath, string(filepath.Separator))
+	for i := range components {
+		if matched, _ := filepath.Match(pattern, filepath.Join(components[i:]...)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Stop watching basePath and everything underneath it.
+func unwatchFilesInDirectory(watcher *watcherSet, watched map[string]bool, basePath string) {
+	filepath.Walk(basePath, func(path string, info os.FileInfo, err error) error {
+		if err == nil {
+			watcher.Remove(path)
+			delete(watched, filepath.Clean(path))
+		}
+		return nil
+	})
+}
+
+// Stop watching a source and everything under it after it has been deleted or moved. The paths
+// can't be walked any more, so this goes by what was watched instead.
+func unwatchRemovedSource(watcher *watcherSet, watched map[string]bool, source string) {
+	prefix := source + string(filepath.Separator)
+	for path := range watched {
+		if path == source || strings.HasPrefix(path, prefix) {
+			watcher.Remove(path)
+			delete(watched, path)
+		}
+	}
+}
+
+// Forget about watches that the kernel dropped when path was removed or renamed, so they're
+// added again if it comes back. A renamed directory takes the watches of everything under it
+// along with it, so those are forgotten too.
+func forgetWatches(watched map[string]bool, path string, renamed bool) {
+	delete(watched, path)
+	if !renamed {
+		return
+	}
+	prefix := path + string(filepath.Separator)
+	for watchedPath := range watched {
+		if strings.HasPrefix(watchedPath, prefix) {
+			delete(watched, watchedPath)
+		}
+	}
+}
+
+// Report whether a file at path passes the mapping's include_extensions and exclude_extensions.
+func hasAllowedExtension(mapping *Mapping, path string) bool {
+	ext := filepath.Ext(path)
+	for _, excluded := range mapping.ExcludeExtensions {
+		if ext == excluded {
+			return false
+		}
+	}
+	if len(mapping.IncludeExtensions) == 0 {
+		return true
+	}
+	for _, included := range mapping.IncludeExtensions {
+		if ext == included {
+			return true
+		}
+	}
+	return false
+}
+
+// The number of levels path is below mapping.Source, which is itself at depth 0.
+func pathDepth(mapping *Mapping, path string) int {
+	relPath, err := filepath.Rel(mapping.Source, path)
+	if err != nil || relPath == "." {
+		return 0
+	}
+	return strings.Count(filepath.ToSlash(relPath), "/") + 1
+}
+
+// Report whether path is dir or somewhere underneath it.
+func isWithin(path, dir string) bool {
+	path, dir = filepath.Clean(path), filepath.Clean(dir)
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
@@ -0,0 +1,43 @@
+package autorsync
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchesGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		// Without a slash, a pattern matches the last path component at any depth.
+		{pattern: "*.log", path: "debug.log", want: true},
+		{pattern: "*.log", path: "a/b/debug.log", want: true},
+		{pattern: "*.log", path: "a/b/debug.txt", want: false},
+		{pattern: "node_modules", path: "web/node_modules", want: true},
+		{pattern: "node_modules/", path: "web/node_modules", want: true},
+		{pattern: "?.tmp", path: "x/1.tmp", want: true},
+		{pattern: "[ab].txt", path: "c.txt", want: false},
+		// A leading slash anchors the pattern to the source.
+		{pattern: "/build", path: "build", want: true},
+		{pattern: "/build", path: "src/build", want: false},
+		{pattern: "/docs/*.md", path: "docs/README.md", want: true},
+		// A slash elsewhere matches the whole path from the source.
+		{pattern: "docs/*.md", path: "docs/README.md", want: true},
+		{pattern: "docs/*.md", path: "src/docs/README.md", want: false},
+		{pattern: "docs/*.md", path: "docs/api/README.md", want: false},
+		// A leading **/ lets it start at any directory.
+		{pattern: "**/docs/*.md", path: "src/docs/README.md", want: true},
+		{pattern: "**/docs/*.md", path: "docs/README.md", want: true},
+		{pattern: "**/docs/*.md", path: "src/docs/api/README.md", want: false},
+		{pattern: "**/*.log", path: "a/b/debug.log", want: true},
+	}
+
+	for _, test := range tests {
+		path := filepath.FromSlash(test.path)
+		if got := matchesGlob(test.pattern, path); got != test.want {
+			t.Errorf("matchesGlob(%q, %q) = %v, want %v", test.pattern, test.path, got, test.want)
+		}
+	}
+}
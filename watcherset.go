@@ -0,0 +1,134 @@
+package autorsync
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/dcrodman/autorsync/internal/logging"
+	"github.com/fsnotify/fsnotify"
+)
+
+// A set of fsnotify watchers, one for each type of filesystem that watched paths are on, with
+// their events and errors merged. Filesystems like FUSE and overlayfs can misbehave under
+// inotify, and giving them their own watcher keeps that from affecting watches elsewhere.
+type watcherSet struct {
+	Events chan fsnotify.Event
+	Errors chan error
+
+	mutex    sync.Mutex
+	watchers map[string]*fsnotify.Watcher
+	// The filesystem type of each watched path, for finding its watcher again.
+	paths  map[string]string
+	closed chan struct{}
+}
+
+func newWatcherSet() *watcherSet {
+	return &watcherSet{
+		Events:   make(chan fsnotify.Event),
+		Errors:   make(chan error),
+		watchers: make(map[string]*fsnotify.Watcher),
+		paths:    make(map[string]string),
+		closed:   make(chan struct{}),
+	}
+}
+
+// Add starts watching path with the watcher for its filesystem, creating one if needed.
+func (ws *watcherSet) Add(path string) error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	select {
+	case <-ws.closed:
+		return errors.New("watcher is closed")
+	default:
+	}
+
+	fsType := filesystemType(path)
+	watcher, ok := ws.watchers[fsType]
+	if !ok {
+		var err error
+		if watcher, err = fsnotify.NewWatcher(); err != nil {
+			return err
+		}
+		if len(ws.watchers) > 0 {
+			logging.Infof("using a separate watcher for paths on %s, starting with %s", filesystemLabel(fsType), path)
+		}
+		ws.watchers[fsType] = watcher
+		go ws.forward(watcher)
+	}
+
+	if err := watcher.Add(path); err != nil {
+		return err
+	}
+	ws.paths[path] = fsType
+	return nil
+}
+
+// Remove stops watching path.
+func (ws *watcherSet) Remove(path string) error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	fsType, ok := ws.paths[path]
+	if !ok {
+		return errors.New("can't remove non-existent watch")
+	}
+	delete(ws.paths, path)
+	return ws.watchers[fsType].Remove(path)
+}
+
+// Close closes every watcher. Nothing more is sent on Events or Errors afterwards.
+func (ws *watcherSet) Close() error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	select {
+	case <-ws.closed:
+		return nil
+	default:
+	}
+	close(ws.closed)
+
+	var closeErr error
+	for _, watcher := range ws.watchers {
+		if err := watcher.Close(); err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+	return closeErr
+}
+
+// Pass watcher's events and errors on to the set's channels until the set is closed.
+func (ws *watcherSet) forward(watcher *fsnotify.Watcher) {
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			select {
+			case ws.Events <- event:
+			case <-ws.closed:
+				return
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			select {
+			case ws.Errors <- err:
+			case <-ws.closed:
+				return
+			}
+		case <-ws.closed:
+			return
+		}
+	}
+}
+
+func filesystemLabel(fsType string) string {
+	if fsType == "" {
+		return "an unknown filesystem"
+	}
+	return fsType
+}
@@ -0,0 +1,36 @@
+package autorsync
+
+import (
+	"encoding/json"
+)
+
+// ChangeRecord is written for each mapping with changes when Options.WatchOnly is set.
+type ChangeRecord struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+	// The most recent change seen under the source. Empty when the mapping was only marked
+	// dirty by SyncNow.
+	EventPath string `json:"event_path,omitempty"`
+}
+
+// Write a line of JSON to the watch output for each of the dirty mappings in place of syncing
+// them.
+func (s *Syncer) reportChanges(dirty []*Mapping) {
+	encoder := json.NewEncoder(s.options.WatchOutput)
+	for _, mapping := range dirty {
+		s.needsRsyncMutex.Lock()
+		record := ChangeRecord{
+			Name:      mapping.label(),
+			Source:    mapping.Source,
+			Target:    mapping.Target,
+			EventPath: mapping.lastEventPath,
+		}
+		mapping.lastEventPath = ""
+		s.needsRsyncMutex.Unlock()
+
+		if err := encoder.Encode(record); err != nil {
+			mappingLog(mapping).Errorf("failed to report change: %v", err)
+		}
+	}
+}
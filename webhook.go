@@ -0,0 +1,95 @@
+package autorsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The body of the request sent to a mapping's webhook_url after it syncs.
+type webhookPayload struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+	// In seconds, like the event log.
+	Duration float64 `json:"duration"`
+	ExitCode int     `json:"exit_code"`
+}
+
+// Validate the mapping's webhook settings and fill in their defaults.
+func parseWebhook(mapping *Mapping) error {
+	u, err := url.Parse(mapping.WebhookURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook_url for %s: %w", mapping.Source, err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("webhook_url for %s must be an http or https URL: %s", mapping.Source, mapping.WebhookURL)
+	}
+
+	mapping.WebhookMethod = strings.ToUpper(mapping.WebhookMethod)
+	if mapping.WebhookMethod == "" {
+		mapping.WebhookMethod = http.MethodPost
+	}
+	if mapping.WebhookRetries < 0 {
+		return fmt.Errorf("webhook_retries for %s must not be negative: %d", mapping.Source, mapping.WebhookRetries)
+	}
+
+	mapping.webhookTimeout, err = parseDurationSetting("webhook_timeout for "+mapping.Source, mapping.WebhookTimeout, 10*time.Second)
+	return err
+}
+
+// Tell the mapping's webhook that it synced in duration. Non-2xx responses count as
+// failures, and each attempt after the first waits a second longer than the last.
+func sendWebhook(ctx context.Context, mapping *Mapping, duration time.Duration) error {
+	body, err := json.Marshal(webhookPayload{
+		Name:     mapping.label(),
+		Source:   mapping.Source,
+		Target:   mapping.syncTarget,
+		Duration: duration.Seconds(),
+	})
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: mapping.webhookTimeout}
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Duration(attempt) * time.Second):
+			case <-ctx.Done():
+				return fmt.Errorf("webhook for %s cancelled: %w", mapping.label(), ctx.Err())
+			}
+		}
+
+		err = postWebhook(ctx, client, mapping, body)
+		if err == nil {
+			return nil
+		}
+		if attempt >= mapping.WebhookRetries {
+			return fmt.Errorf("webhook for %s failed: %w", mapping.label(), err)
+		}
+		mappingLog(mapping).Warnf("webhook for %s failed, retrying: %v", mapping.label(), err)
+	}
+}
+
+func postWebhook(ctx context.Context, client *http.Client, mapping *Mapping, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, mapping.WebhookMethod, mapping.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s returned %s", mapping.WebhookMethod, mapping.WebhookURL, resp.Status)
+	}
+	return nil
+}